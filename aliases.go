@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// aliasMap maps artist names that normalization can't reconcile, like
+// "Ye" and "Kanye West", to one canonical name. It is persisted as a
+// JSON object of canonical name to its variants.
+type aliasMap struct {
+	mu      sync.RWMutex
+	path    string // empty keeps the map in memory only
+	aliases map[string][]string
+	lookup  map[string]string // variant, normalized -> canonical name
+	changed time.Time
+}
+
+// artistAliases is the alias map, loaded in main.
+var artistAliases *aliasMap
+
+// loadAliasMap reads the map at path. A missing file is an empty map.
+func loadAliasMap(path string) (*aliasMap, error) {
+	m := &aliasMap{path: path}
+	m.set(map[string][]string{})
+	if path == "" {
+		return m, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(path); err == nil {
+		m.changed = fi.ModTime()
+	}
+	var aliases map[string][]string
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := validateAliases(aliases); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	m.set(aliases)
+	return m, nil
+}
+
+// validateAliases rejects a variant listed under two canonical names,
+// which would make its resolution depend on map order.
+func validateAliases(aliases map[string][]string) error {
+	owner := make(map[string]string)
+	for canon, variants := range aliases {
+		for _, v := range append([]string{canon}, variants...) {
+			k := normalize(v, NormalizeConfig{})
+			if prev, ok := owner[k]; ok && prev != canon {
+				return fmt.Errorf("%q is an alias of both %q and %q", v, prev, canon)
+			}
+			owner[k] = canon
+		}
+	}
+	return nil
+}
+
+// set installs aliases and rebuilds the lookup. m.mu must be held unless
+// m is still being loaded.
+func (m *aliasMap) set(aliases map[string][]string) {
+	m.aliases = aliases
+	m.lookup = make(map[string]string)
+	for canon, variants := range aliases {
+		for _, v := range variants {
+			m.lookup[normalize(v, NormalizeConfig{})] = canon
+		}
+	}
+}
+
+// Resolve returns the canonical name for an artist name, or name itself
+// if it is no known variant. A nil map resolves nothing.
+func (m *aliasMap) Resolve(name string) string {
+	if m == nil {
+		return name
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.lookup) == 0 {
+		return name
+	}
+	if canon, ok := m.lookup[normalize(name, NormalizeConfig{})]; ok {
+		return canon
+	}
+	return name
+}
+
+// Aliases returns the map as canonical name to variants.
+func (m *aliasMap) Aliases() map[string][]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make(map[string][]string, len(m.aliases))
+	for k, v := range m.aliases {
+		out[k] = append([]string{}, v...)
+	}
+	return out
+}
+
+// ModTime returns when the map last changed. A nil map never has.
+func (m *aliasMap) ModTime() time.Time {
+	if m == nil {
+		return time.Time{}
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.changed
+}
+
+// Replace validates aliases, saves them and makes them the map. On a
+// failed save the old map stays in place.
+func (m *aliasMap) Replace(aliases map[string][]string) error {
+	if err := validateAliases(aliases); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.path != "" {
+		data, err := json.MarshalIndent(aliases, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(m.path, append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	m.set(aliases)
+	m.changed = time.Now()
+	return nil
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServeAPI registers the JSON endpoints on mux.
+func ServeAPI(mux *http.ServeMux) {
+	mux.HandleFunc("/api/config", handleConfig)
+	mux.HandleFunc("/api/force-present", handleForcePresent)
+	mux.HandleFunc("/api/ignore", handleIgnore)
+	mux.HandleFunc("/api/aliases", handleAliases)
+	mux.HandleFunc("/api/list-diff", handleListDiff)
+	mux.HandleFunc("/api/compare", handleCompare)
+	mux.HandleFunc("/compare/stream", handleCompareStream)
+	mux.HandleFunc("/api/server-info", handleServerInfo)
+	mux.HandleFunc("/refresh", handleRefresh)
+	mux.HandleFunc("/api/history", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(history.Entries())
+	})
+	mux.HandleFunc("/api/bad-metadata", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		cfg, err := configFromRequest(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, errCodeInvalidConfig, err.Error())
+			return
+		}
+		library, _ := currentLibrary()
+		bad := badMetadata(library, cfg)
+		if bad == nil {
+			bad = []Album{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(bad)
+	})
+	mux.HandleFunc("/api/diff", func(w http.ResponseWriter, r *http.Request) {
+		all, loadedAt := currentLibrary()
+		var rym []Album
+		var skipped []LineError
+		var modTime time.Time // set for GET, whose results can be cached
+		switch r.Method {
+		case http.MethodPost:
+			src, err := readCSVUpload(r)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+				return
+			}
+			rym, skipped, err = parseListCSV(src, csvOptionsFrom(r))
+			if err != nil {
+				writeParseError(w, err)
+				return
+			}
+		case http.MethodGet:
+			// Re-run against the last uploaded list.
+			var uploaded time.Time
+			if rym, uploaded = lastRYMList(); rym == nil {
+				writeJSONError(w, http.StatusNotFound, errCodeNotFound, "no RYM list uploaded yet")
+				return
+			}
+			modTime = diffModTime(uploaded, loadedAt)
+		default:
+			writeJSONError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		opts, err := parseViewOptions(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
+		}
+		cfg, err := configFromRequest(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, errCodeInvalidConfig, err.Error())
+			return
+		}
+
+		resolveReleaseGroups(r.Context(), cfg, all, rym)
+		if r.Method == http.MethodPost {
+			// Only a request that is answered replaces the last list.
+			rememberRYM(rym)
+			e, missing := summarizeDiff(all, rym, cfg)
+			recordDiff(e, missing)
+			opts.diffed = &missing
+		}
+		rym = opts.filterRYM(rym)
+		library := opts.filterLibrary(all)
+
+		w.Header().Set("Content-Type", "application/json")
+		if len(skipped) > 0 {
+			w.Header().Set("X-Skipped-Lines", strconv.Itoa(len(skipped)))
+		}
+		if bad := badMetadata(all, cfg); len(bad) > 0 {
+			w.Header().Set("X-Bad-Metadata", strconv.Itoa(len(bad)))
+		}
+		// Uploads stream straight out. Re-runs are buffered instead, so
+		// the ETag can be computed and pollers get a 304 when nothing
+		// changed.
+		var out io.Writer = w
+		var buf bytes.Buffer
+		if r.Method == http.MethodGet {
+			out = &buf
+		}
+		switch opts.Format {
+		case "opml":
+			w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="missing.opml"`)
+			created := modTime // so an unchanged re-run keeps its ETag
+			if created.IsZero() {
+				created = time.Now()
+			}
+			err = writeOPML(out, "Albums missing from RYM", created, opts.missingAlbums(library, rym, cfg))
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			if opts.View == "coverage" {
+				w.Header().Set("Content-Disposition", `attachment; filename="coverage.csv"`)
+				err = writeCoverageCSV(out, coverageByArtist(library, rym, cfg, opts.Sort == "coverage"))
+				break
+			}
+			if opts.View == "reverse" {
+				w.Header().Set("Content-Disposition", `attachment; filename="not-in-library.csv"`)
+				err = writeAlbumsCSV(out, opts.Fields, notInLibrary(library, rym, cfg))
+				break
+			}
+			if opts.View == "report" {
+				w.Header().Set("Content-Disposition", `attachment; filename="report.csv"`)
+				err = writeReportCSV(out, combinedReport(library, rym, cfg))
+				break
+			}
+			w.Header().Set("Content-Disposition", `attachment; filename="missing.csv"`)
+			err = writeAlbumsCSV(out, opts.Fields, opts.missingAlbums(library, rym, cfg))
+		case "ids", "ids_json":
+			ids := matchedRYMIDs(findMatches(library, rym, cfg, opts.Confidence), opts.Confidence == ConfidenceTentative)
+			if opts.Format == "ids_json" {
+				err = json.NewEncoder(out).Encode(ids)
+				break
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, id := range ids {
+				if _, err = io.WriteString(out, id+"\n"); err != nil {
+					break
+				}
+			}
+		default:
+			err = writeDiffJSON(out, library, rym, cfg, opts)
+		}
+		if err != nil {
+			// Headers are already sent; all we can do is note it.
+			log.Printf("api/diff: write response: %v", err)
+			return
+		}
+		if r.Method == http.MethodGet {
+			serveCached(w, r, buf.Bytes(), modTime)
+		}
+	})
+}
+
+// writeDiffJSON writes the JSON result of a diff for the view in opts.
+func writeDiffJSON(out io.Writer, library, rym []Album, cfg MatchConfig, opts viewOptions) error {
+	aw := newJSONArrayWriter(out)
+	export := func(a Album) any { return a }
+	if len(opts.Fields) > 0 {
+		export = func(a Album) any { return selectFields(a, opts.Fields) }
+	}
+	var err error
+	var matches []Match
+	switch opts.View {
+	case "matches":
+		matches = findMatches(library, rym, cfg, opts.Confidence)
+	case "title_matches":
+		matches = findTitleMatches(library, rym, cfg)
+	case "review":
+		_, matches = opts.missingAndReview(library, rym, cfg)
+	case "decades":
+		for _, g := range groupByDecade(opts.missingAlbums(library, rym, cfg)) {
+			if err = aw.Write(g); err != nil {
+				break
+			}
+		}
+	case "coverage":
+		for _, c := range coverageByArtist(library, rym, cfg, opts.Sort == "coverage") {
+			if err = aw.Write(c); err != nil {
+				break
+			}
+		}
+	case "reverse":
+		for _, a := range notInLibrary(library, rym, cfg) {
+			if err = aw.Write(export(a)); err != nil {
+				break
+			}
+		}
+	case "report":
+		for _, row := range combinedReport(library, rym, cfg) {
+			if err = aw.Write(row); err != nil {
+				break
+			}
+		}
+	case "missing":
+		if opts.Sort != "" {
+			for _, a := range opts.missingAlbums(library, rym, cfg) {
+				if err = aw.Write(export(a)); err != nil {
+					break
+				}
+			}
+			break
+		}
+		err = opts.eachMissing(library, rym, cfg, func(m missingAlbum) error {
+			if !opts.keep(m.Album) || m.needsReview(cfg) {
+				return nil
+			}
+			return aw.Write(export(m.Album))
+		})
+	}
+	for _, m := range matches {
+		if err = aw.Write(m); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = aw.Close()
+	}
+	return err
+}
+
+// latest returns the latest of ts.
+func latest(ts ...time.Time) time.Time {
+	var out time.Time
+	for _, t := range ts {
+		if t.After(out) {
+			out = t
+		}
+	}
+	return out
+}
+
+// diffModTime is when the result of a diff of the list uploaded at
+// uploaded against the library loaded at loadedAt last changed: the
+// latest of those and of the config and key lists it depends on.
+func diffModTime(uploaded, loadedAt time.Time) time.Time {
+	return latest(uploaded, loadedAt, configModTime(), forcePresent.ModTime(), ignored.ModTime(), artistAliases.ModTime())
+}
+
+// serveCached writes body with an ETag derived from it and modTime, and
+// with modTime as Last-Modified, answering conditional requests with 304
+// Not Modified when they still match.
+func serveCached(w http.ResponseWriter, r *http.Request, body []byte, modTime time.Time) {
+	h := sha256.New()
+	h.Write(body)
+	fmt.Fprint(h, modTime.UnixNano())
+	w.Header().Set("ETag", `"`+hex.EncodeToString(h.Sum(nil)[:16])+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(body))
+}
+
+// handleServerInfo reports which Jellyfin server the library comes from.
+func handleServerInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	info, err := serverInfo.Get(r.Context())
+	if err != nil && info.ID == "" {
+		writeJSONError(w, http.StatusBadGateway, errCodeUpstream, "fetch server info: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(info)
+}
+
+// maxConfigBytes bounds the body accepted by POST /api/config.
+const maxConfigBytes = 64 << 10
+
+// handleConfig serves the active MatchConfig on GET and replaces it on
+// POST. Fields omitted from a POST body keep their current values, and
+// an invalid config leaves the active one untouched.
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			writeJSONError(w, http.StatusUnsupportedMediaType, errCodeUnsupportedType, "expected application/json")
+			return
+		}
+		cfg := currentConfig()
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxConfigBytes))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			writeJSONError(w, http.StatusBadRequest, errCodeInvalidConfig, "invalid config: "+err.Error())
+			return
+		}
+		if err := setConfig(cfg); err != nil {
+			writeJSONError(w, http.StatusBadRequest, errCodeInvalidConfig, "invalid config: "+err.Error())
+			return
+		}
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(currentConfig())
+}
+
+// handleForcePresent lists the force-present keys on GET. POST adds one,
+// given either a Jellyfin "id" or an "artist" and "title", which are
+// stored as their albumKey.
+func handleForcePresent(w http.ResponseWriter, r *http.Request) {
+	serveKeyList(w, r, forcePresent, "force-present list")
+}
+
+// serveKeyList serves l, called name in errors, as handleForcePresent
+// describes.
+func serveKeyList(w http.ResponseWriter, r *http.Request, l *keyList, name string) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			ID     string `json:"id"`
+			Artist string `json:"artist"`
+			Title  string `json:"title"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxConfigBytes)).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, errCodeBadRequest, "invalid body: "+err.Error())
+			return
+		}
+		key := strings.TrimSpace(req.ID)
+		if key == "" {
+			if strings.TrimSpace(req.Artist) == "" || strings.TrimSpace(req.Title) == "" {
+				writeJSONError(w, http.StatusBadRequest, errCodeBadRequest, "need an id, or an artist and a title")
+				return
+			}
+			key = albumKey(Album{AlbumArtist: req.Artist, Name: req.Title}, currentConfig())
+		}
+		if err := l.Add(key); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, errCodeInternal, "save "+name+": "+err.Error())
+			return
+		}
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(l.Keys())
+}
+
+// handleAliases serves the artist alias map on GET and replaces it on
+// POST, with a JSON object of canonical name to variants.
+func handleAliases(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var aliases map[string][]string
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxConfigBytes)).Decode(&aliases); err != nil {
+			writeJSONError(w, http.StatusBadRequest, errCodeBadRequest, "invalid body: "+err.Error())
+			return
+		}
+		if aliases == nil {
+			aliases = map[string][]string{}
+		}
+		if err := validateAliases(aliases); err != nil {
+			writeJSONError(w, http.StatusBadRequest, errCodeBadRequest, "invalid aliases: "+err.Error())
+			return
+		}
+		if err := artistAliases.Replace(aliases); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, errCodeInternal, "save aliases: "+err.Error())
+			return
+		}
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(artistAliases.Aliases())
+}
+
+// jsonArrayWriter streams values as a JSON array, one element at a time,
+// so large results never have to be held in memory as a whole.
+type jsonArrayWriter struct {
+	w     io.Writer
+	enc   *json.Encoder
+	flush func()
+	n     int
+}
+
+func newJSONArrayWriter(w io.Writer) *jsonArrayWriter {
+	aw := &jsonArrayWriter{w: w, enc: json.NewEncoder(w), flush: func() {}}
+	if f, ok := w.(http.Flusher); ok {
+		aw.flush = f.Flush
+	}
+	return aw
+}
+
+// Write appends v to the array, emitting the opening bracket first.
+func (aw *jsonArrayWriter) Write(v any) error {
+	sep := ","
+	if aw.n == 0 {
+		sep = "["
+	}
+	if _, err := io.WriteString(aw.w, sep); err != nil {
+		return err
+	}
+	if err := aw.enc.Encode(v); err != nil {
+		return err
+	}
+	aw.n++
+	if aw.n%100 == 0 {
+		aw.flush()
+	}
+	return nil
+}
+
+// Close terminates the array. An array with no elements is written as [].
+func (aw *jsonArrayWriter) Close() error {
+	end := "]\n"
+	if aw.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(aw.w, end)
+	aw.flush()
+	return err
+}
+
+// Codes for errors not caused by the CSV itself; see also CSVError.
+const (
+	errCodeBadRequest       = "bad_request"
+	errCodeNotFound         = "not_found"
+	errCodeMethodNotAllowed = "method_not_allowed"
+	errCodeUnsupportedType  = "unsupported_media_type"
+	errCodeInvalidConfig    = "invalid_config"
+	errCodeInternal         = "internal"
+	errCodeUpstream         = "upstream"
+)
+
+// apiError is the JSON envelope for every API error response.
+type apiError struct {
+	Error           string   `json:"error"`
+	Code            string   `json:"code"`
+	DetectedColumns int      `json:"detected_columns,omitempty"`
+	ExpectedColumns int      `json:"expected_columns,omitempty"`
+	MissingColumns  []string `json:"missing_columns,omitempty"`
+	Line            int      `json:"line,omitempty"`
+}
+
+func writeJSONError(w http.ResponseWriter, status int, code, msg string) {
+	writeAPIError(w, status, apiError{Error: msg, Code: code})
+}
+
+// writeParseError reports a parseRymCSV failure: 400 with the CSVError's
+// code if the upload was at fault, 500 otherwise.
+func writeParseError(w http.ResponseWriter, err error) {
+	var ce *CSVError
+	if !errors.As(err, &ce) {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, "parse error: "+err.Error())
+		return
+	}
+	writeAPIError(w, http.StatusBadRequest, apiError{
+		Error:           "parse error: " + ce.Error(),
+		Code:            ce.Code,
+		DetectedColumns: ce.Detected,
+		ExpectedColumns: ce.Expected,
+		MissingColumns:  ce.Missing,
+		Line:            ce.Line,
+	})
+}
+
+func writeAPIError(w http.ResponseWriter, status int, e apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(e)
+}
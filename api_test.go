@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestResultsAnswerConditionalRequests(t *testing.T) {
+	withLibrary(t, sampleLibrary())
+	withRYMList(t, sampleCSV)
+	mux := http.NewServeMux()
+	ServeRymCSVForm(mux)
+	ServeAPI(mux)
+	tests := []struct {
+		name, path string
+	}{
+		{"JSON", "/api/diff"},
+		{"CSV", "/api/diff?format=csv"},
+		{"OPML", "/api/diff?format=opml"},
+		{"export", "/export.csv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := serve(t, mux, http.MethodGet, tt.path, nil)
+			etag := resp.Header.Get("ETag")
+			if resp.StatusCode != http.StatusOK || etag == "" {
+				t.Fatalf("status %d, ETag %q; want 200 with an ETag", resp.StatusCode, etag)
+			}
+			if resp.Header.Get("Last-Modified") == "" {
+				t.Error("no Last-Modified")
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("If-None-Match", etag)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != http.StatusNotModified {
+				t.Errorf("with If-None-Match: status %d, want 304", rec.Code)
+			}
+
+			setLibrary(sampleLibrary()[1:])
+			t.Cleanup(func() { setLibrary(sampleLibrary()) })
+			if resp, _ := serve(t, mux, http.MethodGet, tt.path, nil); resp.Header.Get("ETag") == etag {
+				t.Error("ETag unchanged after the library changed")
+			}
+		})
+	}
+}
+
+func TestJSONArrayWriterIsValidJSON(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 100, 10000} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			rec := httptest.NewRecorder() // a Flusher, as in the server
+			aw := newJSONArrayWriter(rec)
+			for i := range n {
+				if err := aw.Write(Album{ID: fmt.Sprint(i), Name: `"quoted", [bracketed]`}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := aw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			var got []Album
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if len(got) != n {
+				t.Errorf("decoded %d albums, want %d", len(got), n)
+			}
+		})
+	}
+}
+
+func TestDiffStreamsValidJSON(t *testing.T) {
+	library, rym := syntheticLists(400, 200)
+	withLibrary(t, library)
+	var csv strings.Builder
+	csv.WriteString(sampleCSV[:strings.Index(sampleCSV, "\n")+1])
+	for i, a := range rym {
+		fmt.Fprintf(&csv, "%q,\"\",%q,\"\",\"\",%q,\"%d\",\"\",\"\",\"\",\"\",\"\",\"\"\n", fmt.Sprint(i), a.AlbumArtist, a.Name, a.ProductionYear)
+	}
+	mux := http.NewServeMux()
+	ServeAPI(mux)
+	tests := []struct {
+		name, view string
+	}{
+		{"missing", "missing"},
+		{"reverse", "reverse"},
+		{"matches", "matches"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := serve(t, mux, http.MethodPost, "/api/diff?view="+tt.view, url.Values{"csvtext": {csv.String()}})
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status %d: %.200s", resp.StatusCode, body)
+			}
+			var got []json.RawMessage
+			if err := json.Unmarshal([]byte(body), &got); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if len(got) == 0 {
+				t.Error("empty result")
+			}
+		})
+	}
+}
+
+func TestRejectedUploadKeepsTheLastList(t *testing.T) {
+	withLibrary(t, sampleLibrary())
+	want := withRYMList(t, sampleCSV)
+	mux := http.NewServeMux()
+	ServeRymCSVForm(mux)
+	ServeAPI(mux)
+	other := sampleCSV[:strings.Index(sampleCSV, "\n")+1] + `"9","","Blur","","","Parklife","1994","8","n","","","",""` + "\n"
+	tests := []struct {
+		name, target string
+		form         url.Values
+	}{
+		{"bad view", "/api/diff?view=nope", url.Values{"csvtext": {other}}},
+		{"bad config", "/api/diff", url.Values{"csvtext": {other}, "threshold": {"2"}}},
+		{"bad config on the form", "/", url.Values{"csvtext": {other}, "threshold": {"2"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serve(t, mux, http.MethodPost, tt.target, tt.form)
+			if got, _ := lastRYMList(); len(got) != len(want) || got[0].Name != want[0].Name {
+				t.Errorf("last list = %v, want the one uploaded before", got)
+			}
+		})
+	}
+}
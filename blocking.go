@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// lengthIndex blocks RYM albums by the rune length of their normalized
+// titles. Edit distance is at least the difference in length, so a pair
+// whose lengths are too far apart can't reach a similarity threshold
+// and never needs comparing; unlike blocking on, say, the first letter,
+// that can't lose a true match to a typo. It only holds in
+// ModeLevenshtein, where similarity is 1 - distance/longer length.
+type lengthIndex struct {
+	byLen map[int][]int // title length -> indices into the RYM list, ascending
+}
+
+func newLengthIndex(rym []albumKeys) *lengthIndex {
+	ix := &lengthIndex{byLen: make(map[int][]int)}
+	for i, k := range rym {
+		for _, t := range k.titles {
+			n := len([]rune(t))
+			if l := ix.byLen[n]; len(l) == 0 || l[len(l)-1] != i {
+				ix.byLen[n] = append(l, i)
+			}
+		}
+	}
+	return ix
+}
+
+// candidates returns, in ascending order, the indices of the RYM albums
+// with a title whose similarity to the normalized title could exceed
+// threshold. For a title n runes long that takes a length in
+// (threshold*n, n/threshold); the range is rounded outwards so float
+// error can't narrow it. Without a threshold ok is false.
+func (ix *lengthIndex) candidates(title string, threshold float64) (idx []int, ok bool) {
+	if threshold <= 0 {
+		return nil, false
+	}
+	n := float64(len([]rune(title)))
+	lo, hi := int(math.Floor(threshold*n)), int(math.Ceil(n/threshold))
+	for l := lo; l <= hi; l++ {
+		idx = append(idx, ix.byLen[l]...)
+	}
+	slices.Sort(idx)
+	return slices.Compact(idx), true // an album with two titles can be in two buckets
+}
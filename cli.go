@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+)
+
+// runCLI diffs the RYM CSV at path ("" or "-" for stdin) against library
+// and writes the library albums missing from it to out as a table, JSON
+// or CSV, for running from cron or a pipeline without the web server.
+func runCLI(out io.Writer, library []Album, path, format string) error {
+	switch format {
+	case "table", "json", "csv":
+	default:
+		return fmt.Errorf("unknown format %q (want table, json or csv)", format)
+	}
+	src := io.Reader(os.Stdin)
+	if path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		src = f
+	}
+	rym, skipped, err := parseListCSV(src, csvOptions{})
+	if err != nil {
+		return err
+	}
+	for _, le := range skipped {
+		log.Printf("skipped %v", le)
+	}
+
+	cfg := currentConfig()
+	resolveReleaseGroups(context.Background(), cfg, library, rym)
+	missing := []Album{}
+	err = forEachMissing(library, rym, cfg, func(m missingAlbum) error {
+		missing = append(missing, m.Album)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(missing)
+	case "csv":
+		return writeAlbumsCSV(out, nil, missing)
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ARTIST\tTITLE\tYEAR")
+	for _, a := range missing {
+		year := ""
+		if y := a.Year(); y > 0 {
+			year = fmt.Sprint(y)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", a.AlbumArtist, a.Name, year)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// comparison is the /api/compare result. Matched pairs carry their title,
+// artist and combined similarities.
+type comparison struct {
+	Threshold         float64 `json:"threshold"`
+	Matched           []Match `json:"matched"`
+	MissingInJellyfin []Album `json:"missing_in_jellyfin"` // on the RYM list only
+	MissingInRYM      []Album `json:"missing_in_rym"`      // in the library only
+}
+
+// compareLibrary matches library against rym both ways. progress, if
+// not nil, is called as albums are done, of total on both sides, and
+// aborts the comparison by returning false; the result is then partial.
+func compareLibrary(library, rym []Album, cfg MatchConfig, progress func(done, total int) bool) comparison {
+	c := comparison{
+		Threshold:         cfg.EffectiveThreshold(),
+		Matched:           []Match{},
+		MissingInJellyfin: []Album{},
+		MissingInRYM:      []Album{},
+	}
+	library = prepareLibrary(library, cfg)
+	forward, reverse := newMatcher(rym, cfg), newMatcher(library, cfg)
+	if progress != nil {
+		var done atomic.Int64
+		total := len(library) + len(rym)
+		forward.progress = func(n int) bool { return progress(int(done.Add(int64(n))), total) }
+		reverse.progress = forward.progress
+	}
+	for i, r := range forward.bestAll(library) {
+		switch {
+		case r.ok:
+			c.Matched = append(c.Matched, r.match)
+		case !isForcedPresent(library[i], cfg):
+			c.MissingInRYM = append(c.MissingInRYM, library[i])
+		}
+	}
+	// As notInLibrary does, against the prepared library.
+	for i, r := range reverse.bestAll(rym) {
+		if !r.ok && !isIgnored(rym[i], cfg) {
+			c.MissingInJellyfin = append(c.MissingInJellyfin, rym[i])
+		}
+	}
+	return c
+}
+
+// handleCompare compares a RYM CSV with the library in one JSON document.
+// The CSV comes as a form upload like /api/diff's or as the raw body,
+// sent as text/csv, text/plain or, gzipped, application/gzip. Unlike
+// /api/diff it leaves no trace: the list isn't kept for re-runs and no
+// history is recorded.
+func handleCompare(w http.ResponseWriter, r *http.Request) {
+	library, rym, cfg, ok := readComparison(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(compareLibrary(library, rym, cfg, nil))
+}
+
+// readComparison reads the list and config of a comparison request as
+// handleCompare describes. It reports false once it has written an error.
+func readComparison(w http.ResponseWriter, r *http.Request) (library, rym []Album, cfg MatchConfig, ok bool) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return nil, nil, cfg, false
+	}
+	var src io.Reader
+	var err error
+	switch mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt {
+	case "text/csv", "text/plain", "application/gzip":
+		src, err = gunzipCSV(http.MaxBytesReader(w, r.Body, 16<<20)) // as for uploads
+	default:
+		src, err = readCSVUpload(r)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return nil, nil, cfg, false
+	}
+	if cfg, err = configFromRequest(r); err != nil {
+		writeJSONError(w, http.StatusBadRequest, errCodeInvalidConfig, err.Error())
+		return nil, nil, cfg, false
+	}
+	if rym, _, err = parseListCSV(src, csvOptionsFrom(r)); err != nil {
+		writeParseError(w, err)
+		return nil, nil, cfg, false
+	}
+	library, _ = currentLibrary()
+	resolveReleaseGroups(r.Context(), cfg, library, rym)
+	return library, rym, cfg, true
+}
+
+// compareProgressInterval is how often /compare/stream reports progress.
+const compareProgressInterval = 250 * time.Millisecond
+
+// handleCompareStream runs the comparison of handleCompare as a stream
+// of server-sent events: "progress" events with the albums done so far
+// and the total, then one "result" event carrying the comparison. It
+// stops comparing when the client goes away.
+func handleCompareStream(w http.ResponseWriter, r *http.Request) {
+	flusher, canFlush := w.(http.Flusher)
+	if !canFlush {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, "streaming unsupported")
+		return
+	}
+	library, rym, cfg, ok := readComparison(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	var done, total atomic.Int64
+	result := make(chan comparison, 1) // buffered, so an abandoned run can finish
+	go func() {
+		result <- compareLibrary(library, rym, cfg, func(d, t int) bool {
+			done.Store(int64(d))
+			total.Store(int64(t))
+			return ctx.Err() == nil
+		})
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	emit := func(event string, v any) bool {
+		data, err := json.Marshal(v)
+		if err == nil {
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+		}
+		if err != nil {
+			log.Printf("compare/stream: %v", err)
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+	type progress struct {
+		Done  int64 `json:"done"`
+		Total int64 `json:"total"`
+	}
+	tick := time.NewTicker(compareProgressInterval)
+	defer tick.Stop()
+	var last int64 = -1
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if d := done.Load(); d != last {
+				if !emit("progress", progress{d, total.Load()}) {
+					return
+				}
+				last = d
+			}
+		case c := <-result:
+			if emit("progress", progress{done.Load(), total.Load()}) {
+				emit("result", c)
+			}
+			return
+		}
+	}
+}
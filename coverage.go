@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// artistCoverage is one row of the coverage view: how many of an
+// artist's library albums are on the RYM list.
+type artistCoverage struct {
+	Artist   string  `json:"artist"`
+	Total    int     `json:"total"`
+	Matched  int     `json:"matched"`
+	Coverage float64 `json:"coverage_pct"` // Matched out of Total, in percent
+}
+
+// coverageByArtist tallies the library albums found in rym per artist,
+// grouping albums whose artists normalize alike under the first name
+// seen. Albums that are not missing, forced present ones included,
+// count as matched. The rows come sorted by artist, or by coverage,
+// highest first, if byCoverage is set.
+func coverageByArtist(library, rym []Album, cfg MatchConfig, byCoverage bool) []artistCoverage {
+	library = prepareLibrary(library, cfg)
+	var out []artistCoverage
+	rows := make(map[string]int)
+	for i, r := range newMatcher(rym, cfg).bestAll(library) {
+		a := library[i]
+		key := cfg.artistKey(a.AlbumArtist)
+		j, ok := rows[key]
+		if !ok {
+			j = len(out)
+			rows[key] = j
+			out = append(out, artistCoverage{Artist: a.AlbumArtist})
+		}
+		out[j].Total++
+		if r.ok || isForcedPresent(a, cfg) {
+			out[j].Matched++
+		}
+	}
+	for i := range out {
+		c := &out[i]
+		c.Coverage = math.Round(1000*float64(c.Matched)/float64(c.Total)) / 10
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if byCoverage && a.Coverage != b.Coverage {
+			return a.Coverage > b.Coverage
+		}
+		if byCoverage && a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return strings.ToLower(a.Artist) < strings.ToLower(b.Artist)
+	})
+	return out
+}
+
+// writeCoverageCSV writes rows as CSV with a header.
+func writeCoverageCSV(w io.Writer, rows []artistCoverage) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"artist", "total", "matched", "coverage_pct"}); err != nil {
+		return err
+	}
+	for _, c := range rows {
+		row := []string{
+			c.Artist,
+			strconv.Itoa(c.Total),
+			strconv.Itoa(c.Matched),
+			strconv.FormatFloat(c.Coverage, 'f', -1, 64),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// csvURLFetcher downloads a RYM export from a user-supplied URL, with
+// limits so a slow or huge response can't hang the server or exhaust
+// its memory.
+type csvURLFetcher struct {
+	HTTP         *http.Client
+	Timeout      time.Duration
+	MaxBytes     int64
+	AllowedHosts []string // empty allows any host
+}
+
+// csvFetch is configured from flags in main.
+var csvFetch = csvURLFetcher{
+	HTTP:     &http.Client{},
+	Timeout:  15 * time.Second,
+	MaxBytes: 16 << 20,
+}
+
+// csvContentTypes are the media types accepted from a CSV URL. Servers
+// often label CSV files as plain text or generic binary.
+var csvContentTypes = []string{
+	"text/csv", "text/plain", "application/csv",
+	"application/vnd.ms-excel", "application/octet-stream",
+}
+
+// maxCSVRedirects is how many redirects a CSV fetch follows.
+const maxCSVRedirects = 5
+
+// checkURL reports whether u may be fetched: an http or https URL on an
+// allowed host.
+func (f csvURLFetcher) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("csvurl: scheme %q not allowed (want http or https)", u.Scheme)
+	}
+	if len(f.AllowedHosts) > 0 && !slices.Contains(f.AllowedHosts, strings.ToLower(u.Hostname())) {
+		return fmt.Errorf("csvurl: host %q is not in the allowed list", u.Hostname())
+	}
+	return nil
+}
+
+// Fetch downloads rawURL and returns its body. Redirects are followed
+// only to URLs checkURL allows, so an allowed host can't send the fetch
+// elsewhere.
+func (f csvURLFetcher) Fetch(ctx context.Context, rawURL string) (io.Reader, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, fmt.Errorf("csvurl: %w", err)
+	}
+	if err := f.checkURL(u); err != nil {
+		return nil, err
+	}
+	hc := *f.HTTP
+	hc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxCSVRedirects {
+			return fmt.Errorf("csvurl: more than %d redirects", maxCSVRedirects)
+		}
+		return f.checkURL(req.URL)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("csvurl: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("csvurl: bad status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || !slices.Contains(csvContentTypes, mt) {
+			return nil, fmt.Errorf("csvurl: unexpected content type %q", ct)
+		}
+	}
+	if resp.ContentLength > f.MaxBytes {
+		return nil, fmt.Errorf("csvurl: response is %d bytes, limit is %d", resp.ContentLength, f.MaxBytes)
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(resp.Body, f.MaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("csvurl: read body: %w", err)
+	}
+	if n > f.MaxBytes {
+		return nil, fmt.Errorf("csvurl: response exceeds %d bytes", f.MaxBytes)
+	}
+	return &buf, nil
+}
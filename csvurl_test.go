@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVFetchRedirects(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		io.WriteString(w, "from other host")
+	}))
+	defer other.Close()
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/list.csv":
+			w.Header().Set("Content-Type", "text/csv")
+			io.WriteString(w, "from allowed host")
+		case "/moved":
+			http.Redirect(w, r, "/list.csv", http.StatusFound)
+		case "/away":
+			http.Redirect(w, r, other.URL+"/list.csv", http.StatusFound)
+		case "/file":
+			http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	// Both servers listen on 127.0.0.1, so tell them apart by name.
+	allowed := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	f := csvURLFetcher{HTTP: &http.Client{}, Timeout: 5 * time.Second, MaxBytes: 1 << 20, AllowedHosts: []string{"localhost"}}
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr string
+	}{
+		{"/list.csv", "from allowed host", ""},
+		{"/moved", "from allowed host", ""},
+		{"/away", "", `host "127.0.0.1" is not in the allowed list`},
+		{"/file", "", `scheme "file" not allowed`},
+		{"/loop", "", "redirects"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			body, err := f.Fetch(context.Background(), allowed+tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Fetch error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(body)
+			if string(b) != tt.want {
+				t.Errorf("body = %q, want %q", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVFetchRejectsDisallowedURL(t *testing.T) {
+	f := csvURLFetcher{HTTP: &http.Client{}, Timeout: time.Second, MaxBytes: 1, AllowedHosts: []string{"example.com"}}
+	for _, raw := range []string{"ftp://example.com/x.csv", "http://evil.test/x.csv"} {
+		if _, err := f.Fetch(context.Background(), raw); err == nil {
+			t.Errorf("Fetch(%q) succeeded, want an error", raw)
+		}
+	}
+}
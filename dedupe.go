@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+)
+
+// duplicateGroup is a set of library albums that match each other, as
+// when one was imported twice under slightly different names.
+type duplicateGroup struct {
+	Albums []Album `json:"albums"`
+	Score  float64 `json:"score"` // the lowest of its pairs' scores
+}
+
+// findDuplicates matches library against itself as a diff matches it
+// against RYM, and returns the clusters of albums that matched, in
+// library order. An album joins a cluster by matching any one of its
+// albums, in either direction.
+func findDuplicates(library []Album, cfg MatchConfig) []duplicateGroup {
+	library = prepareLibrary(library, cfg)
+	m := newMatcher(library, cfg)
+	threshold := cfg.EffectiveThreshold()
+
+	// Scores of the pairs i < j that matched, the best of both ways.
+	pairs := make(map[[2]int]float64)
+	for i, a := range library {
+		m.eachMatch(a, m.queryKeys(a), threshold, func(j int, match Match) bool {
+			if i != j {
+				p := [2]int{min(i, j), max(i, j)}
+				pairs[p] = max(pairs[p], match.Score)
+			}
+			return true
+		})
+	}
+
+	parent := make([]int, len(library))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	for p := range pairs {
+		if a, b := root(p[0]), root(p[1]); a != b {
+			parent[max(a, b)] = min(a, b) // the root is a cluster's first album
+		}
+	}
+
+	byRoot := make(map[int]*duplicateGroup)
+	var roots []int
+	for i, a := range library {
+		r := root(i)
+		g, ok := byRoot[r]
+		if !ok {
+			g = &duplicateGroup{Score: 1}
+			byRoot[r] = g
+			roots = append(roots, r)
+		}
+		g.Albums = append(g.Albums, a)
+	}
+	for p, score := range pairs {
+		g := byRoot[root(p[0])]
+		g.Score = min(g.Score, score)
+	}
+	var out []duplicateGroup
+	for _, r := range roots {
+		if g := byRoot[r]; len(g.Albums) > 1 {
+			out = append(out, *g)
+		}
+	}
+	return slices.Clip(out)
+}
+
+// handleDedupe shows the duplicates within the library. It takes the
+// view and config parameters of the other pages; a RYM list isn't
+// needed.
+func handleDedupe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	opts, err := formViewOptions(r)
+	opts.View = "duplicates"
+	if err != nil {
+		renderBadRequest(w, err, opts)
+		return
+	}
+	cfg, err := configFromRequest(r)
+	if err != nil {
+		renderBadRequest(w, err, opts)
+		return
+	}
+	renderForm(w, nil, "", nil, opts, cfg)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// List sources a CSV can be exported from; see csvOptions.Source.
+const (
+	sourceRYM     = "rym"
+	sourceDiscogs = "discogs"
+)
+
+// The Discogs collection export columns discogsAlbums reads.
+const (
+	colDiscogsArtist   = "Artist"
+	colDiscogsTitle    = "Title"
+	colDiscogsReleased = "Released"
+	colDiscogsID       = "release_id"
+)
+
+// providerDiscogs keys a Discogs release ID in Album.ProviderIDs.
+const providerDiscogs = "Discogs"
+
+// discogsArtistSuffix matches what Discogs appends to an artist name: a
+// number telling apart artists of the same name, as in "Nirvana (2)",
+// or an asterisk marking a name variation.
+var discogsArtistSuffix = regexp.MustCompile(`(?:\s*\(\d+\)|\*)+$`)
+
+// parseListCSV parses an album list exported from opts.Source or, if
+// that is empty, whichever source the header looks like: Discogs if it
+// has a release_id column but no RYM Album one, RYM otherwise.
+func parseListCSV(r io.Reader, opts csvOptions) ([]Album, []LineError, error) {
+	albums, _, bad, err := parseListRows(r, opts)
+	return albums, bad, err
+}
+
+// parseListRows is parseListCSV, also returning how many cells of its
+// row each album had filled in, for dedupeRYM to merge lists by.
+func parseListRows(r io.Reader, opts csvOptions) ([]Album, []int, []LineError, error) {
+	t, err := readCSVTable(r, opts)
+	if err != nil {
+		return nil, nil, t.bad, err
+	}
+	source := opts.Source
+	if source == "" {
+		source = sourceRYM
+		hdr := trimAll(t.rows[0])
+		if len(columnsNamed(hdr, colDiscogsID)) > 0 && len(columnsNamed(hdr, colRYMID)) == 0 {
+			source = sourceDiscogs
+		}
+	}
+	switch source {
+	case sourceRYM:
+		return rymAlbums(t, opts)
+	case sourceDiscogs:
+		return discogsAlbums(t, opts)
+	}
+	return nil, nil, t.bad, &CSVError{Code: CSVErrUnknownSource, Err: fmt.Errorf("unknown source %q (want rym or discogs)", source)}
+}
+
+// discogsAlbums reads the albums of a Discogs collection export. Its
+// Label and Format columns have no counterpart in Album and are left
+// out; the release ID is kept under providerDiscogs. The filled-cell
+// counts are those dedupeRYM returns.
+func discogsAlbums(t *csvTable, opts csvOptions) ([]Album, []int, []LineError, error) {
+	bad := t.bad
+	hdr := trimAll(t.rows[0])
+	col := make(map[string]int)
+	var missing []string
+	for _, name := range []string{colDiscogsArtist, colDiscogsTitle, colDiscogsReleased, colDiscogsID} {
+		if idx := columnsNamed(hdr, name); len(idx) > 0 {
+			col[name] = idx[0]
+		} else if name == colDiscogsArtist || name == colDiscogsTitle {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, nil, bad, &CSVError{
+			Code:     CSVErrMissingColumns,
+			Detected: len(hdr),
+			Missing:  missing,
+			Err:      fmt.Errorf("Discogs header lacks %s", strings.Join(missing, ", ")),
+		}
+	}
+
+	var out []Album
+	var filled []int
+	for i := 1; i < len(t.rows); i++ {
+		cols := trimAll(t.rows[i])
+		cell := func(name string) string {
+			if c, ok := col[name]; ok && c < len(cols) {
+				return cols[c]
+			}
+			return ""
+		}
+		alb := Album{
+			Name:        cell(colDiscogsTitle),
+			AlbumArtist: strings.TrimSpace(discogsArtistSuffix.ReplaceAllString(cell(colDiscogsArtist), "")),
+		}
+		if normalize(alb.Name, NormalizeConfig{}) == "" || normalize(alb.AlbumArtist, NormalizeConfig{}) == "" {
+			le := t.lineError(t.lines[i], errEmptyName)
+			if opts.RejectEmptyNames {
+				return nil, nil, bad, &CSVError{Code: CSVErrEmptyName, Line: le.Line, Err: le}
+			}
+			bad = append(bad, le)
+			continue
+		}
+		if d, ok := parseYearOrDate(cell(colDiscogsReleased)); ok {
+			alb.ReleaseDate = d
+			alb.ProductionYear = d.Year()
+		}
+		if id := cell(colDiscogsID); id != "" {
+			alb.ProviderIDs = map[string]string{providerDiscogs: id}
+		}
+		alb.Artists = splitArtists(alb.AlbumArtist)
+		out = append(out, alb)
+		filled = append(filled, nonEmpty(cols))
+	}
+	out, filled = dedupeRYM(out, filled)
+	return out, filled, bad, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// albumFields are the fields the exports can be limited to, in the
+// order the CSV export lists them by default.
+var albumFields = []struct {
+	Name  string
+	Value func(Album) any
+}{
+	{"artist", func(a Album) any { return a.AlbumArtist }},
+	{"title", func(a Album) any { return a.Name }},
+	{"year", func(a Album) any { return a.Year() }},
+	{"id", func(a Album) any { return a.ID }},
+	{"rym_id", func(a Album) any { return a.RYMAlbumID }},
+	{"plays", func(a Album) any { return a.PlayCount() }},
+	{"favorite", func(a Album) any { return a.IsFavorite() }},
+	{"image_tag", func(a Album) any { return a.PrimaryImageTag }},
+	{"overview", func(a Album) any { return a.Overview }},
+	{"merged", func(a Album) any { return a.Merged }},
+}
+
+// parseFields validates a comma-separated list of albumFields names. An
+// empty list selects none, meaning the full album.
+func parseFields(s string) ([]string, error) {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		if fieldValue(f) == nil {
+			names := make([]string, len(albumFields))
+			for i, af := range albumFields {
+				names[i] = af.Name
+			}
+			return nil, fmt.Errorf("unknown field %q (want %s)", f, strings.Join(names, ", "))
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+func fieldValue(name string) func(Album) any {
+	for _, af := range albumFields {
+		if af.Name == name {
+			return af.Value
+		}
+	}
+	return nil
+}
+
+// selectFields returns just the named fields of a, for the JSON export.
+func selectFields(a Album, fields []string) map[string]any {
+	out := make(map[string]any, len(fields))
+	for _, f := range fields {
+		out[f] = fieldValue(f)(a)
+	}
+	return out
+}
+
+// writeAlbumsCSV writes albums as CSV with a header row, limited to
+// fields, or with all albumFields if there are none.
+func writeAlbumsCSV(w io.Writer, fields []string, albums []Album) error {
+	if len(fields) == 0 {
+		for _, af := range albumFields {
+			fields = append(fields, af.Name)
+		}
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(fields); err != nil {
+		return err
+	}
+	row := make([]string, len(fields))
+	for _, a := range albums {
+		for i, f := range fields {
+			switch v := fieldValue(f)(a).(type) {
+			case string:
+				row[i] = v
+			case int:
+				row[i] = strconv.Itoa(v)
+			case bool:
+				row[i] = strconv.FormatBool(v)
+			case []string:
+				row[i] = strings.Join(v, "; ")
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// OPML documents, as read by feed readers and outliners, many of which
+// also import outlines of arbitrary items. Each album is an outline
+// whose text reads "Artist – Title (Year)", with the parts also given
+// as their own attributes for tools that map fields.
+type opmlDoc struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Title   string        `xml:"head>title"`
+	Created string        `xml:"head>dateCreated"`
+	Items   []opmlOutline `xml:"body>outline"`
+}
+
+type opmlOutline struct {
+	Text   string `xml:"text,attr"`
+	Type   string `xml:"type,attr"`
+	Artist string `xml:"artist,attr"`
+	Album  string `xml:"album,attr"`
+	Year   int    `xml:"year,attr,omitempty"`
+	ID     string `xml:"jellyfinId,attr,omitempty"`
+}
+
+// writeOPML writes albums as an OPML 2.0 outline dated created.
+func writeOPML(w io.Writer, title string, created time.Time, albums []Album) error {
+	doc := opmlDoc{Version: "2.0", Title: title, Created: created.UTC().Format(time.RFC1123Z)}
+	for _, a := range albums {
+		text := a.AlbumArtist + " – " + a.Name
+		if y := a.Year(); y > 0 {
+			text += fmt.Sprintf(" (%d)", y)
+		}
+		doc.Items = append(doc.Items, opmlOutline{
+			Text: text, Type: "album",
+			Artist: a.AlbumArtist, Album: a.Name, Year: a.Year(), ID: a.ID,
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// matchedRYMIDs returns the distinct RYM IDs of matches, in the order
+// given, for building a RYM list from the library. Tentative
+// matches are left out unless tentative is set.
+func matchedRYMIDs(matches []Match, tentative bool) []string {
+	ids := []string{}
+	seen := make(map[string]bool)
+	for _, m := range matches {
+		id := m.RYM.RYMAlbumID
+		if id == "" || seen[id] || (m.Tentative && !tentative) {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeJellyfin is an in-memory Jellyfin server for tests. It serves
+// Albums from /Items and /Users/{id}/Items in pages, the libraries in
+// Libraries from /Library/VirtualFolders and UserID from /Users/Me.
+type fakeJellyfin struct {
+	Albums   []Album
+	PageSize int // the most albums a page holds, whatever Limit asks; 0 for no cap
+	Total    int // the TotalRecordCount reported; 0 reports len(Albums)
+
+	// ByParent, if set, holds the albums of each library, served when
+	// a request has that ParentId.
+	ByParent  map[string][]Album
+	Libraries []VirtualFolder
+	UserID    string
+
+	// Token, if set, must come with every request, as the
+	// X-MediaBrowser-Token header or the api_key parameter, or it gets
+	// a 401.
+	Token string
+
+	// Fail holds, for the album page starting at each index, the
+	// statuses its first requests get before it is served.
+	Fail map[int][]int
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+// newFakeJellyfin starts f and returns a client for it that retries
+// without waiting long.
+func newFakeJellyfin(t *testing.T, f *fakeJellyfin) *Client {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	c := NewClient(srv.URL, f.Token)
+	c.HTTP = srv.Client()
+	c.RetryDelay = time.Millisecond
+	return c
+}
+
+// Requests returns the requests f has had so far.
+func (f *fakeJellyfin) Requests() []*http.Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*http.Request(nil), f.requests...)
+}
+
+func (f *fakeJellyfin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r)
+	f.mu.Unlock()
+
+	if f.Token != "" && r.Header.Get("X-MediaBrowser-Token") != f.Token && r.URL.Query().Get("api_key") != f.Token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.URL.Path == "/Items" || strings.HasPrefix(r.URL.Path, "/Users/") && strings.HasSuffix(r.URL.Path, "/Items"):
+		f.serveItems(w, r)
+	case r.URL.Path == "/Users/Me":
+		if f.UserID == "" {
+			http.Error(w, "no user", http.StatusUnauthorized)
+			return
+		}
+		writeFakeJSON(w, map[string]string{"Id": f.UserID})
+	case r.URL.Path == "/Library/VirtualFolders":
+		writeFakeJSON(w, f.Libraries)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeJellyfin) serveItems(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, _ := strconv.Atoi(q.Get("StartIndex"))
+	limit, err := strconv.Atoi(q.Get("Limit"))
+	if err != nil {
+		http.Error(w, "bad Limit", http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	if fail := f.Fail[start]; len(fail) > 0 {
+		f.Fail[start] = fail[1:]
+		f.mu.Unlock()
+		http.Error(w, "injected failure", fail[0])
+		return
+	}
+	f.mu.Unlock()
+
+	albums := f.Albums
+	if id := q.Get("ParentId"); id != "" {
+		albums = f.ByParent[id]
+	}
+	if f.PageSize > 0 {
+		limit = min(limit, f.PageSize)
+	}
+	start = min(start, len(albums))
+	total := len(albums)
+	if f.Total > 0 {
+		total = f.Total
+	}
+	writeFakeJSON(w, itemsResponse{Items: albums[start:min(start+limit, len(albums))], TotalRecordCount: total})
+}
+
+func writeFakeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// numberedAlbums returns n albums with IDs "0" to n-1, in that order.
+func numberedAlbums(n int) []Album {
+	albums := make([]Album, n)
+	for i := range albums {
+		id := strconv.Itoa(i)
+		albums[i] = Album{ID: id, Name: "Album " + id, AlbumArtist: "Artist"}
+	}
+	return albums
+}
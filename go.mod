@@ -7,4 +7,4 @@ require (
 	golang.org/x/text v0.29.0
 )
 
-require github.com/mattn/go-sqlite3 v1.14.32 // indirect
+require github.com/mattn/go-sqlite3 v1.14.32
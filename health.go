@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// healthChecker tells whether Jellyfin is reachable, remembering the
+// answer for TTL so frequent probes don't all reach the server.
+type healthChecker struct {
+	Client  *Client
+	TTL     time.Duration
+	Timeout time.Duration // per check, retries included
+
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+var health = &healthChecker{TTL: 5 * time.Second, Timeout: 3 * time.Second}
+
+// Check returns nil if Jellyfin answered its public system info call.
+func (h *healthChecker) Check(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.Client == nil {
+		return errNoServer
+	}
+	if !h.checked.IsZero() && time.Since(h.checked) < h.TTL {
+		return h.err
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+	_, err := h.Client.GetServerInfo(ctx)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err // probes needn't learn the server's URL
+		}
+		err = fmt.Errorf("jellyfin unreachable: %w", err)
+	}
+	h.err, h.checked = err, time.Now()
+	return err
+}
+
+// ServeHealth registers /healthz, which answers 200 while Jellyfin is
+// reachable and 503 with the reason while it isn't.
+func ServeHealth(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSONError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := health.Check(r.Context()); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"status": "unavailable", "reason": err.Error()})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	})
+}
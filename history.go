@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// historyEntry summarizes one diff run.
+type historyEntry struct {
+	Time    time.Time `json:"time"`
+	Library int       `json:"library"` // library albums compared
+	RYM     int       `json:"rym"`     // RYM albums compared against
+	Matched int       `json:"matched"` // library albums found on RYM
+	Missing int       `json:"missing"` // library albums reported missing
+}
+
+// historyLog keeps the latest diff summaries, persisted as JSON lines.
+type historyLog struct {
+	mu      sync.Mutex
+	path    string // empty keeps the history in memory only
+	max     int    // entries kept; older ones are dropped
+	entries []historyEntry
+}
+
+// history is the diff history, loaded in main.
+var history *historyLog
+
+// loadHistory reads the history at path, keeping at most max entries.
+// A missing file is an empty history.
+func loadHistory(path string, max int) (*historyLog, error) {
+	h := &historyLog{path: path, max: max}
+	if path == "" {
+		return h, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var e historyEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		h.entries = append(h.entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	h.trim()
+	return h, nil
+}
+
+// Entries returns the history, oldest first. A nil history is empty.
+func (h *historyLog) Entries() []historyEntry {
+	if h == nil {
+		return []historyEntry{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]historyEntry{}, h.entries...)
+}
+
+// Last returns the latest entry, and false if there is none.
+func (h *historyLog) Last() (historyEntry, bool) {
+	if h == nil {
+		return historyEntry{}, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.entries) == 0 {
+		return historyEntry{}, false
+	}
+	return h.entries[len(h.entries)-1], true
+}
+
+// sameCounts reports whether e and o found the same, whenever they ran.
+func (e historyEntry) sameCounts(o historyEntry) bool {
+	o.Time = e.Time
+	return e == o
+}
+
+// Add records e. Once the history outgrows its bound the file is
+// rewritten with just the entries kept; otherwise e is appended to it.
+func (h *historyLog) Add(e historyEntry) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, e)
+	if h.path == "" {
+		h.trim()
+		return nil
+	}
+	if h.trim() {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, e := range h.entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return writeFileAtomic(h.path, buf.Bytes())
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// trim drops the oldest entries beyond h.max, reporting whether it did.
+// h.mu must be held unless h is still being loaded.
+func (h *historyLog) trim() bool {
+	if h.max <= 0 || len(h.entries) <= h.max {
+		return false
+	}
+	h.entries = append(h.entries[:0], h.entries[len(h.entries)-h.max:]...)
+	return true
+}
+
+// recordDiff adds e, the summary of a diff that found missing, to the
+// history and sends both to the webhook, if one is set. Only the
+// notification, with its retries, outlives the request, until notifyCtx
+// ends.
+func recordDiff(e historyEntry, missing []missingAlbum) {
+	if err := history.Add(e); err != nil {
+		log.Printf("record diff history: %v", err)
+	}
+	go webhook.Notify(notifyCtx, e, missing)
+}
+
+// summarizeDiff diffs library against rym, returning the summary and
+// the missing albums.
+func summarizeDiff(library, rym []Album, cfg MatchConfig) (historyEntry, []missingAlbum) {
+	e := historyEntry{Time: time.Now(), RYM: len(rym)}
+	var missing []missingAlbum
+	library = prepareLibrary(library, cfg)
+	for i, r := range newMatcher(rym, cfg).bestAll(library) {
+		e.Library++
+		if r.ok || isForcedPresent(library[i], cfg) {
+			e.Matched++
+		} else {
+			e.Missing++
+			missing = append(missing, missingAlbum{library[i], r.nearest})
+		}
+	}
+	return e, missing
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDiffHandlersRecordTheirOwnResult(t *testing.T) {
+	withLibrary(t, sampleLibrary())
+	mux := http.NewServeMux()
+	ServeRymCSVForm(mux)
+	ServeAPI(mux)
+	tests := []struct {
+		name, path string
+	}{
+		{"form", "/"},
+		{"API", "/api/diff"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := history
+			history, _ = loadHistory("", 10)
+			t.Cleanup(func() { history = old })
+
+			resp, body := serve(t, mux, http.MethodPost, tt.path, url.Values{"csvtext": {sampleCSV}, "view": {"missing"}})
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status %d: %s", resp.StatusCode, body)
+			}
+			entries := history.Entries()
+			if len(entries) != 1 {
+				t.Fatalf("recorded %d entries, want 1", len(entries))
+			}
+			want := historyEntry{Time: entries[0].Time, Library: 4, RYM: 3, Matched: 3, Missing: 1}
+			if entries[0] != want {
+				t.Errorf("recorded %+v, want %+v", entries[0], want)
+			}
+			if !strings.Contains(body, "Kid A") {
+				t.Errorf("response doesn't list the missing album:\n%s", body)
+			}
+		})
+	}
+}
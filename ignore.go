@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ignored lists RYM albums known not to be in the library, like
+// vinyl-only releases, so the reverse diff doesn't report them. Keys are
+// RYM album IDs or albumKey values. Loaded in main.
+var ignored *keyList
+
+func isIgnored(a Album, cfg MatchConfig) bool {
+	return ignored.Has(a.RYMAlbumID) || ignored.Has(albumKey(a, cfg))
+}
+
+// handleIgnore is handleForcePresent for the ignore list, whose "id" is
+// a RYM album ID.
+func handleIgnore(w http.ResponseWriter, r *http.Request) {
+	serveKeyList(w, r, ignored, "ignore list")
+}
+
+// handleIgnoreForm adds the RYM album posted by a results page's Ignore
+// button, by its "id" or its "artist" and "title", and sends the browser
+// back to the page, whose parameters come as "back".
+func handleIgnoreForm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := strings.TrimSpace(r.FormValue("id"))
+	if key == "" {
+		artist, title := strings.TrimSpace(r.FormValue("artist")), strings.TrimSpace(r.FormValue("title"))
+		if artist == "" || title == "" {
+			http.Error(w, "need an id, or an artist and a title", http.StatusBadRequest)
+			return
+		}
+		key = albumKey(Album{AlbumArtist: artist, Name: title}, currentConfig())
+	}
+	if err := ignored.Add(key); err != nil {
+		http.Error(w, "save ignore list: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/rerun?"+r.FormValue("back"), http.StatusSeeOther)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// keyList is a set of album keys persisted as a JSON array in a file.
+// Keys are Jellyfin item IDs or albumKey values.
+type keyList struct {
+	mu      sync.RWMutex
+	path    string // empty keeps the list in memory only
+	keys    map[string]bool
+	changed time.Time // last Add, or the file's mtime when loaded
+}
+
+// loadKeyList reads the list at path. A missing file is an empty list.
+func loadKeyList(path string) (*keyList, error) {
+	l := &keyList{path: path, keys: make(map[string]bool)}
+	if path == "" {
+		return l, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(path); err == nil {
+		l.changed = fi.ModTime()
+	}
+	var keys []string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, k := range keys {
+		l.keys[k] = true
+	}
+	return l, nil
+}
+
+// Has reports whether key is in the list. A nil list is empty.
+func (l *keyList) Has(key string) bool {
+	if l == nil || key == "" {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.keys[key]
+}
+
+// ModTime returns when the list last changed. A nil list never has.
+func (l *keyList) ModTime() time.Time {
+	if l == nil {
+		return time.Time{}
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.changed
+}
+
+// Keys returns the keys in sorted order.
+func (l *keyList) Keys() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	out := make([]string, 0, len(l.keys))
+	for k := range l.keys {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Add inserts key and saves the list. On a failed save the key is not
+// kept, so memory and disk never disagree.
+func (l *keyList) Add(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.keys[key] {
+		return nil
+	}
+	l.keys[key] = true
+	if err := l.save(); err != nil {
+		delete(l.keys, key)
+		return err
+	}
+	l.changed = time.Now()
+	return nil
+}
+
+// save writes the list atomically via a temporary file. l.mu must be held.
+func (l *keyList) save() error {
+	if l.path == "" {
+		return nil
+	}
+	keys := make([]string, 0, len(l.keys))
+	for k := range l.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(l.path, append(data, '\n'))
+}
+
+// writeFileAtomic replaces the file at path with data via a temporary
+// file, so readers never see it half written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
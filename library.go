@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// libraryCache reloads the library from Client once the last fetch is
+// older than TTL, on the first currentLibrary call after that, so albums
+// added to Jellyfin show up without a restart.
+type libraryCache struct {
+	Client *Client
+	TTL    time.Duration // 0 never refetches on its own
+
+	mu      sync.Mutex // held while fetching, so requests share one fetch
+	fetched time.Time  // of the last fetch, failed or not
+}
+
+var libraryLoader = &libraryCache{}
+
+// errNoServer is returned for what needs Jellyfin when main was given no
+// server to talk to.
+var errNoServer = errors.New("no Jellyfin server configured")
+
+// Refresh fetches the library and replaces the current one with it. On
+// failure the current library is kept.
+func (l *libraryCache) Refresh(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fetch(ctx)
+}
+
+func (l *libraryCache) fetch(ctx context.Context) error {
+	l.fetched = time.Now()
+	if l.Client == nil {
+		return errNoServer
+	}
+	albums, err := l.Client.GetAllAlbums(ctx)
+	if err != nil {
+		return err
+	}
+	setLibrary(albums)
+	return nil
+}
+
+// refreshIfStale refetches the library if it is older than TTL. Callers
+// arriving during the fetch wait for it rather than starting their own.
+// A failed fetch is logged and not retried for another TTL.
+func (l *libraryCache) refreshIfStale() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.Client == nil || l.TTL <= 0 || time.Since(l.fetched) < l.TTL {
+		return
+	}
+	if err := l.fetch(context.Background()); err != nil {
+		log.Printf("refresh library: %v; keeping the old one", err)
+	}
+}
+
+// handleRefresh reloads the library now, answering with its size and
+// load time.
+func handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if libraryLoader.Client == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, errCodeUpstream, errNoServer.Error())
+		return
+	}
+	if err := libraryLoader.Refresh(r.Context()); err != nil {
+		writeJSONError(w, http.StatusBadGateway, errCodeUpstream, "refresh library: "+err.Error())
+		return
+	}
+	library, loadedAt := currentLibrary()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Albums   int       `json:"albums"`
+		LoadedAt time.Time `json:"loaded_at"`
+	}{len(library), loadedAt})
+}
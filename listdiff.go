@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// listDiff is the result of comparing two RYM lists with each other.
+type listDiff struct {
+	OnlyInA []Album `json:"only_in_a"`
+	OnlyInB []Album `json:"only_in_b"`
+}
+
+// diffLists reports the albums of a with no match in b and the other way
+// round. Neither side is a Jellyfin library, so the force-present list
+// and library-only steps like disc collapsing don't apply.
+func diffLists(a, b []Album, cfg MatchConfig) listDiff {
+	d := listDiff{OnlyInA: []Album{}, OnlyInB: []Album{}}
+	mb := newMatcher(b, cfg)
+	for _, alb := range a {
+		if !mb.best(alb).ok {
+			d.OnlyInA = append(d.OnlyInA, alb)
+		}
+	}
+	ma := newMatcher(a, cfg)
+	for _, alb := range b {
+		if !ma.best(alb).ok {
+			d.OnlyInB = append(d.OnlyInB, alb)
+		}
+	}
+	return d
+}
+
+// handleListDiff diffs two uploaded RYM CSVs, "csv_a" and "csv_b" (or the
+// pasted "csvtext_a" and "csvtext_b"), against each other. It needs no
+// Jellyfin library.
+func handleListDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	_ = r.ParseMultipartForm(32 << 20) // two 16 MB lists
+	cfg, err := configFromRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errCodeInvalidConfig, err.Error())
+		return
+	}
+	var lists [2][]Album
+	for i, side := range []string{"a", "b"} {
+		src, err := readNamedCSV(r, "csv_"+side, "csvtext_"+side)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
+		}
+		if lists[i], _, err = parseListCSV(src, csvOptionsFrom(r)); err != nil {
+			var ce *CSVError
+			if errors.As(err, &ce) {
+				ce.Err = fmt.Errorf("list %s: %w", strings.ToUpper(side), ce.Err)
+			}
+			writeParseError(w, err)
+			return
+		}
+	}
+	resolveReleaseGroups(r.Context(), cfg, lists[0], lists[1])
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(diffLists(lists[0], lists[1], cfg))
+}
+
+// readNamedCSV returns the CSV uploaded as file, else the text pasted
+// into field.
+func readNamedCSV(r *http.Request, file, field string) (io.Reader, error) {
+	if f, _, err := r.FormFile(file); err == nil {
+		defer f.Close()
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, f); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		return gunzipCSV(&buf)
+	}
+	if s := r.FormValue(field); s != "" {
+		return strings.NewReader(s), nil
+	}
+	return nil, fmt.Errorf("missing %s", file)
+}
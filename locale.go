@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// localeRules are the language-specific parts of normalize.
+type localeRules struct {
+	tag      language.Tag
+	fold     *strings.Replacer // applied after lowercasing, before accents go
+	articles map[string]bool   // dropped when they start a string
+}
+
+// localeFolds spell out letters whose accent carries a sound the
+// language writes out when the letter isn't available, so "Müller" and
+// "Mueller" agree instead of "Müller" and "Muller".
+var localeFolds = map[language.Base]*strings.Replacer{
+	mustBase("de"): strings.NewReplacer("ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss"),
+}
+
+// localeArticles are the leading articles each language drops.
+var localeArticles = map[language.Base][]string{
+	mustBase("en"): {"the", "a", "an"},
+	mustBase("de"): {"der", "die", "das", "den", "dem", "des", "ein", "eine"},
+	mustBase("fr"): {"le", "la", "les", "un", "une"},
+	mustBase("es"): {"el", "la", "los", "las", "un", "una"},
+	mustBase("it"): {"il", "lo", "la", "i", "gli", "le"},
+	mustBase("nl"): {"de", "het", "een"},
+}
+
+func mustBase(s string) language.Base {
+	return language.MustParseBase(s)
+}
+
+// parseLocale checks a NormalizeConfig locale. Empty is locale-neutral.
+func parseLocale(s string) (language.Tag, error) {
+	if s == "" {
+		return language.Und, nil
+	}
+	tag, err := language.Parse(s)
+	if err != nil {
+		return language.Und, fmt.Errorf("locale %q: %w", s, err)
+	}
+	return tag, nil
+}
+
+// rulesFor returns the rules for locale, or nil for a neutral or
+// invalid one; Validate reports the latter.
+func rulesFor(locale string) *localeRules {
+	tag, err := parseLocale(locale)
+	if err != nil || tag == language.Und {
+		return nil
+	}
+	base, _ := tag.Base()
+	r := &localeRules{tag: tag, fold: localeFolds[base]}
+	if arts := localeArticles[base]; len(arts) > 0 {
+		r.articles = make(map[string]bool, len(arts))
+		for _, a := range arts {
+			r.articles[a] = true
+		}
+	}
+	return r
+}
+
+// lower lowercases s by the locale's rules, e.g. Turkish dotted and
+// dotless i, then applies its folds.
+func (r *localeRules) lower(s string) string {
+	// A Caser keeps state, so each call gets its own.
+	s = cases.Lower(r.tag).String(s)
+	if r.fold != nil {
+		s = r.fold.Replace(s)
+	}
+	return s
+}
+
+// frontArticle moves the article of a sort name like "beatles, the" to
+// the front, where dropArticle finds it.
+func (r *localeRules) frontArticle(s string) string {
+	i := strings.LastIndex(s, ",")
+	if i < 0 {
+		return s
+	}
+	if art := strings.TrimSpace(s[i+1:]); r.articles[art] && strings.TrimSpace(s[:i]) != "" {
+		return art + " " + s[:i]
+	}
+	return s
+}
+
+// dropArticle removes a leading article, unless it is the only word.
+func (r *localeRules) dropArticle(words []string) []string {
+	if len(words) > 1 && r.articles[words[0]] {
+		return words[1:]
+	}
+	return words
+}
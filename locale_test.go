@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestNormalizeLeadingArticles(t *testing.T) {
+	tests := []struct {
+		in, locale, want string
+	}{
+		{"The Beatles", "en", "beatles"},
+		{"Beatles, The", "en", "beatles"},
+		{"Beatles", "en", "beatles"},
+		{"A Tribe Called Quest", "en", "tribe called quest"},
+		{"Tribe Called Quest, A", "en", "tribe called quest"},
+		{"An Horse", "en", "horse"},
+		{"The The", "en", "the"},
+		{"The", "en", "the"}, // the only word
+		{"Theatre of Tragedy", "en", "theatre of tragedy"},
+		{"Earth, Wind and Fire", "en", "earth wind and fire"},
+		{"Die Ärzte", "de", "aerzte"},
+		{"Ärzte, Die", "de", "aerzte"},
+		{"The Beatles", "de", "the beatles"},
+		{"The Beatles", "", "the beatles"}, // disabled
+		{"Beatles, The", "", "beatles the"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale+"/"+tt.in, func(t *testing.T) {
+			if got := normalize(tt.in, NormalizeConfig{Locale: tt.locale}); got != tt.want {
+				t.Errorf("normalize(%q) in %q = %q, want %q", tt.in, tt.locale, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,781 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Confidence levels assigned to a Match.
+const (
+	ConfidenceExact  = "exact"  // normalized artist and title are identical
+	ConfidenceStrong = "strong" // the score is at least strongSimilarity
+	ConfidenceWeak   = "weak"   // cleared the threshold, but only just
+
+	// ConfidenceTentative marks a second-pass match, found only at the
+	// relaxed SecondPassThreshold.
+	ConfidenceTentative = "tentative"
+)
+
+const strongSimilarity = 0.9
+
+// MatchConfig controls how albums are compared. Artist and title are
+// normalized separately since they often want different rules.
+type MatchConfig struct {
+	Threshold  float64         `json:"threshold"`   // the score must exceed this; 0 picks the mode's
+	Mode       string          `json:"mode"`        // ModeLevenshtein (default), ModeTokenSet, ModePhonetic, ModeCombined or ModeJaroWinkler
+	QGramIndex bool            `json:"qgram_index"` // prefilter RYM candidates by shared trigrams
+	Artist     NormalizeConfig `json:"artist"`
+	Title      NormalizeConfig `json:"title"`
+
+	// CollapseDiscs merges library albums that differ only by a disc
+	// suffix, like "The Wall (Disc 1)" and "The Wall (Disc 2)", into a
+	// single album before matching.
+	CollapseDiscs bool `json:"collapse_discs"`
+
+	// MergeEditions treats the deluxe, remastered, anniversary and
+	// similar editions of a library album as that album, reporting them
+	// as one entry that lists the editions present.
+	MergeEditions bool `json:"merge_editions"`
+
+	// SecondPassThreshold, when positive, retries albums that found no
+	// match at Threshold with this lower one. What it finds is reported
+	// as tentative rather than matched or missing.
+	SecondPassThreshold float64 `json:"second_pass_threshold"`
+
+	// ReviewThreshold, when positive, is the lower bound of the "needs
+	// review" band: an album left unmatched whose closest pair scores
+	// above it is listed beside that pair, for a person to confirm,
+	// instead of as missing. The pairs come from those the matcher
+	// scored anyway, so one whose title is too far off to be scored at
+	// the threshold never shows up. The diff history counts them missing.
+	ReviewThreshold float64 `json:"review_threshold"`
+
+	// MaxDistance, when positive, rejects any pair of strings more than
+	// this many edits apart, whatever their similarity. The threshold
+	// alone already lets distance computations stop early.
+	MaxDistance int `json:"max_distance"`
+
+	// Library years outside [MinYear, current year + MaxYearAhead] are
+	// flagged as bad metadata and treated as unknown. The defaults allow
+	// from the first recordings up to albums announced for next year.
+	MinYear      int `json:"min_year"`
+	MaxYearAhead int `json:"max_year_ahead"`
+
+	// YearMode decides what the years of a pair count for: YearIgnore
+	// (the default), YearGate with YearTolerance, or YearSoft with
+	// YearWeight and YearFalloff. Pairs missing a year are never
+	// rejected or penalized for it.
+	YearMode      string  `json:"year_mode"`
+	YearTolerance int     `json:"year_tolerance"`
+	YearWeight    float64 `json:"year_weight"`
+	YearFalloff   float64 `json:"year_falloff"`
+
+	// Scoring is ScoreWeighted (default) or ScoreBoth. The weights are
+	// only used by the former and needn't sum to 1.
+	Scoring      string  `json:"scoring"`
+	TitleWeight  float64 `json:"title_weight"`
+	ArtistWeight float64 `json:"artist_weight"`
+
+	// MinTokenOverlap, in token-set mode, rejects pairs sharing fewer
+	// than this many words, not counting stopwords like "the" or "live"
+	// (see tokenStopwords). Strings with fewer significant words than
+	// that need all of them shared instead. In combined mode such pairs
+	// are scored by edit distance alone.
+	MinTokenOverlap int `json:"min_token_overlap"`
+
+	// Soundtracks detects soundtrack albums (see isSoundtrack), whose
+	// artist is as often the composer, "Various Artists" or the film as
+	// anything RYM lists, and matches them by title: the artist need
+	// only reach SoundtrackArtistThreshold (0.5 by default, enough for
+	// the composer's name spelled differently but not for someone else)
+	// and the score is the title similarity alone.
+	Soundtracks               bool    `json:"soundtracks"`
+	SoundtrackArtistThreshold float64 `json:"soundtrack_artist_threshold"`
+
+	// ReleaseGroups matches albums whose MusicBrainz release groups
+	// agree before any fuzzy matching, so a particular pressing in the
+	// library matches the RYM release. Release IDs without a group are
+	// looked up on MusicBrainz, which is slow the first time.
+	ReleaseGroups bool `json:"release_groups"`
+
+	// Workers is how many goroutines match library albums at once; 0
+	// means one per CPU. Results don't depend on it.
+	Workers int `json:"workers"`
+
+	// ArtistFields lists the Jellyfin fields whose names are tried as
+	// the album's artist; the best-scoring one counts. See artistFields.
+	ArtistFields []string `json:"artist_fields"`
+}
+
+// Jellyfin fields that can supply artist candidates.
+const (
+	ArtistFieldAlbumArtist = "AlbumArtist"
+	ArtistFieldArtists     = "Artists"
+	ArtistFieldComposers   = "Composers"
+)
+
+var artistFields = []string{ArtistFieldAlbumArtist, ArtistFieldArtists, ArtistFieldComposers}
+
+// rymArtistFields are the artist candidates of a RYM album: the credited
+// artist and, for splits, each of the artists in it.
+var rymArtistFields = []string{ArtistFieldAlbumArtist, ArtistFieldArtists}
+
+// DefaultMatchConfig returns the configuration used when none is given.
+func DefaultMatchConfig() MatchConfig {
+	return MatchConfig{
+		CollapseDiscs:             true,
+		Artist:                    NormalizeConfig{Conjunctions: true, Dots: true, Locale: "en"},
+		Title:                     NormalizeConfig{Conjunctions: true, Abbreviations: true, Dots: true, NoiseWords: slices.Clone(defaultTitleNoise)},
+		MinYear:                   1877,
+		MaxYearAhead:              1,
+		YearTolerance:             1,
+		YearWeight:                0.05,
+		YearFalloff:               2,
+		TitleWeight:               0.6,
+		ArtistWeight:              0.4,
+		SoundtrackArtistThreshold: 0.5,
+		ReviewThreshold:           0.5,
+		ArtistFields:              []string{ArtistFieldAlbumArtist, ArtistFieldArtists, ArtistFieldComposers},
+	}
+}
+
+// Validate reports the first out-of-range setting in c.
+func (c MatchConfig) Validate() error {
+	if c.Threshold < 0 || c.Threshold > 1 {
+		return fmt.Errorf("threshold %v out of range [0,1]", c.Threshold)
+	}
+	if _, ok := modeThresholds[c.mode()]; !ok {
+		return fmt.Errorf("unknown mode %q (want levenshtein, token_set, phonetic, combined or jaro_winkler)", c.Mode)
+	}
+	if c.SecondPassThreshold < 0 || c.SecondPassThreshold > c.EffectiveThreshold() {
+		return fmt.Errorf("second_pass_threshold %v out of range [0,threshold]", c.SecondPassThreshold)
+	}
+	if c.ReviewThreshold < 0 || c.ReviewThreshold > 1 {
+		return fmt.Errorf("review_threshold %v out of range [0,1]", c.ReviewThreshold)
+	}
+	if c.MaxDistance < 0 {
+		return fmt.Errorf("max_distance must not be negative")
+	}
+	for _, n := range []NormalizeConfig{c.Artist, c.Title} {
+		if _, err := parseLocale(n.Locale); err != nil {
+			return err
+		}
+	}
+	if c.SoundtrackArtistThreshold < 0 || c.SoundtrackArtistThreshold > 1 {
+		return fmt.Errorf("soundtrack_artist_threshold %v out of range [0,1]", c.SoundtrackArtistThreshold)
+	}
+	if c.Workers < 0 {
+		return fmt.Errorf("workers must not be negative")
+	}
+	if c.MinTokenOverlap < 0 {
+		return fmt.Errorf("min_token_overlap must not be negative")
+	}
+	if c.MaxYearAhead < 0 {
+		return fmt.Errorf("max_year_ahead must not be negative")
+	}
+	if err := c.validateYears(); err != nil {
+		return err
+	}
+	if err := c.validateScoring(); err != nil {
+		return err
+	}
+	if len(c.ArtistFields) == 0 {
+		return fmt.Errorf("artist_fields must not be empty")
+	}
+	for _, f := range c.ArtistFields {
+		if !slices.Contains(artistFields, f) {
+			return fmt.Errorf("unknown artist field %q (want one of %s)", f, strings.Join(artistFields, ", "))
+		}
+	}
+	return nil
+}
+
+var (
+	configMu        sync.RWMutex
+	activeConfig    = DefaultMatchConfig()
+	configChangedAt time.Time
+)
+
+// currentConfig returns the server-wide match configuration.
+func currentConfig() MatchConfig {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	c := activeConfig
+	c.ArtistFields = slices.Clone(c.ArtistFields)
+	c.Artist.NoiseWords = slices.Clone(c.Artist.NoiseWords)
+	c.Title.NoiseWords = slices.Clone(c.Title.NoiseWords)
+	return c
+}
+
+// configModTime returns when the configuration was last replaced.
+func configModTime() time.Time {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return configChangedAt
+}
+
+// setConfig replaces the server-wide match configuration if it is valid.
+func setConfig(c MatchConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	configMu.Lock()
+	activeConfig, configChangedAt = c, time.Now()
+	configMu.Unlock()
+	return nil
+}
+
+// Match pairs a Jellyfin album with the RYM album it was matched against.
+type Match struct {
+	Jellyfin   Album   `json:"jellyfin"`
+	RYM        Album   `json:"rym"`
+	TitleSim   float64 `json:"title_similarity"`
+	ArtistSim  float64 `json:"artist_similarity"`
+	Score      float64 `json:"score"` // the two similarities combined by the scoring rule
+	Confidence string  `json:"confidence"`
+
+	// Tentative marks a match found only by the relaxed second pass.
+	Tentative bool `json:"tentative,omitempty"`
+
+	// TitleOnly marks a pair from the title-only discovery view, where
+	// the artist was ignored and Score is just the title similarity.
+	TitleOnly bool `json:"title_only,omitempty"`
+
+	// Soundtrack marks a match made by title, as for soundtracks.
+	Soundtrack bool `json:"soundtrack,omitempty"`
+
+	// ReleaseGroup marks a match made by MusicBrainz release group.
+	ReleaseGroup bool `json:"release_group,omitempty"`
+}
+
+// matcher compares Jellyfin albums against a fixed list of RYM albums.
+type matcher struct {
+	cfg   MatchConfig
+	rym   []Album
+	keys  []albumKeys  // keys[i] belongs to rym[i]
+	all   []int        // every index into rym, when nothing filters them
+	index *qgramIndex  // nil compares against every RYM album
+	byLen *lengthIndex // in ModeLevenshtein, the fallback to index
+
+	// groups maps MusicBrainz release groups to RYM albums, when
+	// cfg.ReleaseGroups is set.
+	groups map[string]int
+
+	// progress, if set, is told by bestAll how many more albums it has
+	// done, from any of its workers, and stops it by returning false.
+	progress func(n int) bool
+}
+
+// albumKeys are the normalized strings an album is compared by. They
+// are worked out once per album rather than once per comparison.
+type albumKeys struct {
+	titles  []string
+	artists []string
+}
+
+func keysOf(titles, artists []string, cfg MatchConfig) albumKeys {
+	var k albumKeys
+	for _, t := range titles {
+		k.titles = append(k.titles, normalize(t, cfg.Title))
+	}
+	for _, name := range artists {
+		k.artists = append(k.artists, cfg.artistKey(name))
+	}
+	return k
+}
+
+func newMatcher(rym []Album, cfg MatchConfig) *matcher {
+	m := &matcher{cfg: cfg, rym: rym, keys: make([]albumKeys, len(rym)), all: make([]int, len(rym))}
+	for i, a := range rym {
+		m.keys[i] = keysOf(a.titles(), a.artistCandidates(rymArtistFields), cfg)
+		m.all[i] = i
+	}
+	if cfg.QGramIndex && cfg.mode() == ModeLevenshtein {
+		// The index only saves time, so without it all pairs are compared.
+		title, artist := cfg.fieldCutoffs(cfg.yearCutoff(cfg.EffectiveThreshold()))
+		if qgramPrunes(min(title, artist)) {
+			ix, err := newQGramIndex(m.keys, cfg)
+			if err != nil {
+				log.Printf("q-gram index unavailable, comparing all pairs: %v", err)
+			}
+			m.index = ix
+		}
+	}
+	if m.index == nil && cfg.mode() == ModeLevenshtein {
+		m.byLen = newLengthIndex(m.keys)
+	}
+	if cfg.ReleaseGroups {
+		m.groups = make(map[string]int)
+		for i, a := range rym {
+			if g := musicBrainz.releaseGroup(a); g != "" {
+				m.groups[g] = i
+			}
+		}
+	}
+	return m
+}
+
+// best returns the RYM album most similar to a, provided both its title
+// and artist similarity clear the threshold. Failing that, it tries the
+// second-pass threshold, if any, and marks what it finds as tentative.
+// An album left unmatched keeps the closest pair either pass scored.
+func (m *matcher) best(a Album) bestResult {
+	if m.groups != nil {
+		if g := musicBrainz.releaseGroup(a); g != "" {
+			if i, ok := m.groups[g]; ok {
+				return bestResult{match: m.byReleaseGroup(a, m.rym[i]), ok: true}
+			}
+		}
+	}
+	keys := m.queryKeys(a)
+	match, ok := m.bestAt(a, keys, m.cfg.EffectiveThreshold())
+	if ok {
+		match.Confidence = confidenceOf(match)
+		return bestResult{match: match, ok: true}
+	}
+	nearest := match
+	if m.cfg.SecondPassThreshold > 0 {
+		match, ok := m.bestAt(a, keys, m.cfg.SecondPassThreshold)
+		if ok {
+			match.Tentative = true
+			match.Confidence = ConfidenceTentative
+			return bestResult{match: match, ok: true}
+		}
+		if match.Score > nearest.Score {
+			nearest = match
+		}
+	}
+	return bestResult{nearest: nearest}
+}
+
+// byReleaseGroup pairs albums known to be the same release group. The
+// similarities are only informative; the match is certain.
+func (m *matcher) byReleaseGroup(a, rym Album) Match {
+	cfg := m.cfg
+	titleSim := cfg.compare(normalize(a.Name, cfg.Title), normalize(rym.Name, cfg.Title), 0)
+	artistSim := 0.0
+	for _, jfArtist := range a.artistCandidates(cfg.ArtistFields) {
+		for _, rymArtist := range rym.artistCandidates(rymArtistFields) {
+			artistSim = max(artistSim, cfg.compare(cfg.artistKey(jfArtist), cfg.artistKey(rymArtist), 0))
+		}
+	}
+	return Match{
+		Jellyfin: a, RYM: rym,
+		TitleSim: titleSim, ArtistSim: artistSim, Score: 1,
+		Confidence: ConfidenceExact, ReleaseGroup: true,
+	}
+}
+
+// bestResult is the outcome of best for one album.
+type bestResult struct {
+	match Match
+	ok    bool
+
+	// nearest, when nothing matched, is the closest pair best scored on
+	// the way, zero if no title came near enough to be scored.
+	nearest Match
+}
+
+// bestMatchChunk is how many albums a bestAll worker takes at a time.
+const bestMatchChunk = 64
+
+// bestAll runs best on every album of library, spread over cfg.Workers
+// goroutines that take chunks of it in turn. The results are in library
+// order, so they are the same whatever the number of workers. If
+// m.progress stops it, the albums not yet done are left unmatched.
+func (m *matcher) bestAll(library []Album) []bestResult {
+	out := make([]bestResult, len(library))
+	workers := m.cfg.Workers
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	chunks := (len(library) + bestMatchChunk - 1) / bestMatchChunk
+	workers = min(workers, chunks)
+	// run does chunk c and reports whether to go on.
+	run := func(c int) bool {
+		start, end := c*bestMatchChunk, min((c+1)*bestMatchChunk, len(library))
+		for i := start; i < end; i++ {
+			out[i] = m.best(library[i])
+		}
+		return m.progress == nil || m.progress(end-start)
+	}
+	if workers <= 1 {
+		for c := range chunks {
+			if !run(c) {
+				break
+			}
+		}
+		return out
+	}
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				c := int(next.Add(1)) - 1
+				if c >= chunks || !run(c) {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+// queryKeys returns the keys a is looked up by: its name and any
+// alternative titles, like a RYM album's localized one, and the artists
+// of cfg.ArtistFields and its alternative names.
+func (m *matcher) queryKeys(a Album) albumKeys {
+	artists := a.artistCandidates(m.cfg.ArtistFields)
+	for _, n := range a.AltArtists {
+		if !slices.Contains(artists, n) {
+			artists = append(artists, n)
+		}
+	}
+	return keysOf(a.titles(), artists, m.cfg)
+}
+
+// bestAt is best at a single threshold, for a with keys jf. If nothing
+// clears it, it returns the closest pair it scored, with ok false.
+func (m *matcher) bestAt(a Album, jf albumKeys, threshold float64) (best Match, ok bool) {
+	m.eachScored(a, jf, threshold, func(_ int, match Match) bool {
+		switch {
+		case match.Score > threshold:
+			if !ok || match.Score > best.Score {
+				best, ok = match, true
+			}
+			return match.Score < 1 // can't do better than identical
+		case !ok && match.Score > best.Score:
+			best = match
+		}
+		return true
+	})
+	return best, ok
+}
+
+// eachMatch calls yield with every RYM album, and its index, that a with
+// keys jf matches at threshold, until yield returns false. An album a
+// matches under several of its titles is yielded for each.
+func (m *matcher) eachMatch(a Album, jf albumKeys, threshold float64, yield func(i int, match Match) bool) {
+	m.eachScored(a, jf, threshold, func(i int, match Match) bool {
+		return match.Score <= threshold || yield(i, match)
+	})
+}
+
+// eachScored is eachMatch, but also yields the pairs scored at or below
+// threshold: all those whose title is close enough to be worth scoring.
+func (m *matcher) eachScored(a Album, jf albumKeys, threshold float64, yield func(i int, match Match) bool) {
+	for _, title := range jf.titles {
+		if !m.eachScoredOf(a, title, jf.artists, threshold, yield) {
+			return
+		}
+	}
+}
+
+// eachScoredOf is eachScored for one of a's titles. It reports whether
+// yield let it finish.
+func (m *matcher) eachScoredOf(a Album, jfTitle string, jfArtists []string, threshold float64, yield func(i int, match Match) bool) bool {
+	cfg := m.cfg
+
+	jfSoundtrack := cfg.Soundtracks && isSoundtrack(a)
+	titleCutoff, artistCutoff := cfg.fieldCutoffs(cfg.yearCutoff(threshold))
+	var candidates []int
+	filtered := false
+	switch {
+	case m.index != nil && !jfSoundtrack:
+		// The index filters by artist too, which soundtracks can't rely on.
+		candidates, filtered = m.index.candidates(jfArtists, jfTitle, min(titleCutoff, artistCutoff))
+	case m.byLen != nil:
+		candidates, filtered = m.byLen.candidates(jfTitle, titleCutoff)
+	}
+	if !filtered {
+		candidates = m.all
+	}
+
+	for _, i := range candidates {
+		rymAlbum, rymKeys := m.rym[i], m.keys[i]
+		titleSim := 0.0
+		for _, t := range rymKeys.titles {
+			titleSim = max(titleSim, cfg.compare(jfTitle, t, titleCutoff))
+		}
+		if titleSim <= titleCutoff {
+			continue
+		}
+		soundtrack := jfSoundtrack || (cfg.Soundtracks && isSoundtrack(rymAlbum))
+		artistThreshold := threshold
+		if soundtrack {
+			artistThreshold = min(threshold, cfg.SoundtrackArtistThreshold)
+		}
+		artistSim := 0.0
+		for _, rymArtist := range rymKeys.artists {
+			for _, jfArtist := range jfArtists {
+				artistSim = max(artistSim, cfg.compare(jfArtist, rymArtist, min(artistCutoff, artistThreshold)))
+			}
+		}
+
+		score := cfg.score(titleSim, artistSim)
+		byTitle := false
+		if soundtrack && artistSim <= threshold && artistSim >= artistThreshold {
+			score, byTitle = max(score, titleSim), true
+		}
+		switch cfg.yearMode() {
+		case YearGate:
+			if d, known := yearDiff(a, rymAlbum); known && d > cfg.YearTolerance {
+				continue
+			}
+		case YearSoft:
+			score = min(max(score+cfg.yearAdjustment(a, rymAlbum), 0), 1)
+		}
+		match := Match{Jellyfin: a, RYM: rymAlbum, TitleSim: titleSim, ArtistSim: artistSim, Score: score, Soundtrack: byTitle}
+		if !yield(i, match) {
+			return false
+		}
+	}
+	return true
+}
+
+// soundtrackWords mark a title as a soundtrack's.
+var soundtrackWords = regexp.MustCompile(`(?i)\b(?:soundtrack|ost|original score|motion picture)\b`)
+
+// isSoundtrack guesses whether a is a soundtrack, from a genre naming
+// one or its title.
+func isSoundtrack(a Album) bool {
+	for _, g := range a.Genres {
+		if strings.Contains(strings.ToLower(g), "soundtrack") {
+			return true
+		}
+	}
+	return soundtrackWords.MatchString(a.Name)
+}
+
+func confidenceOf(m Match) string {
+	switch {
+	case m.TitleSim == 1 && m.ArtistSim == 1:
+		return ConfidenceExact
+	case m.Score >= strongSimilarity:
+		return ConfidenceStrong
+	default:
+		return ConfidenceWeak
+	}
+}
+
+// parseConfidence validates a confidence filter value. The empty string
+// means no filtering.
+func parseConfidence(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "", ConfidenceExact, ConfidenceStrong, ConfidenceWeak, ConfidenceTentative:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown confidence %q (want exact, strong, weak or tentative)", s)
+}
+
+// prepareLibrary applies the library-side rewrites cfg asks for before
+// any matching happens. It never modifies library itself.
+func prepareLibrary(library []Album, cfg MatchConfig) []Album {
+	library = flagBadYears(library, cfg)
+	if cfg.CollapseDiscs {
+		library = collapseDiscs(library, cfg)
+	}
+	if cfg.MergeEditions {
+		library = mergeEditions(library, cfg)
+	}
+	return library
+}
+
+// artistKey normalizes an artist name for comparison, first replacing a
+// known alias by its canonical name.
+func (c MatchConfig) artistKey(name string) string {
+	return normalize(artistAliases.Resolve(name), c.Artist)
+}
+
+// plausibleYear reports whether y lies within cfg's year bounds.
+func (c MatchConfig) plausibleYear(y int) bool {
+	return y >= c.MinYear && y <= time.Now().Year()+c.MaxYearAhead
+}
+
+// flagBadYears sets BadYear on the albums with an implausible year,
+// copying library only if there are any.
+func flagBadYears(library []Album, cfg MatchConfig) []Album {
+	var out []Album
+	for i, a := range library {
+		if cfg.plausibleYear(a.ProductionYear) {
+			continue
+		}
+		if out == nil {
+			out = slices.Clone(library)
+		}
+		out[i].BadYear = true
+	}
+	if out == nil {
+		return library
+	}
+	return out
+}
+
+// badMetadata returns the library albums whose metadata looks wrong, for
+// now those with an implausible year.
+func badMetadata(library []Album, cfg MatchConfig) []Album {
+	var out []Album
+	for _, a := range flagBadYears(library, cfg) {
+		if a.BadYear {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// forcePresent lists albums known to be on RYM that the matcher can't
+// link, so they are never reported as missing. Loaded in main.
+var forcePresent *keyList
+
+// albumKey identifies an album by its normalized artist and title, for
+// lists that must also cover albums without a Jellyfin ID.
+func albumKey(a Album, cfg MatchConfig) string {
+	return normalize(a.AlbumArtist, cfg.Artist) + " - " + normalize(a.Name, cfg.Title)
+}
+
+func isForcedPresent(a Album, cfg MatchConfig) bool {
+	return forcePresent.Has(a.ID) || forcePresent.Has(albumKey(a, cfg))
+}
+
+// missingAlbum is a library album the diff matched to nothing, with the
+// closest pair it scored instead (see bestResult).
+type missingAlbum struct {
+	Album
+	nearest Match
+}
+
+// needsReview reports whether m's closest pair falls in the review band
+// between cfg.ReviewThreshold and the threshold.
+func (m missingAlbum) needsReview(cfg MatchConfig) bool {
+	return cfg.ReviewThreshold > 0 && m.nearest.Score > cfg.ReviewThreshold
+}
+
+// forEachMissing calls fn, in library order, for every Jellyfin album
+// with no matching RYM album, not even a tentative one, that isn't on
+// the force-present list. It stops at the first error fn returns.
+func forEachMissing(library, rym []Album, cfg MatchConfig, fn func(missingAlbum) error) error {
+	library = prepareLibrary(library, cfg)
+	for i, r := range newMatcher(rym, cfg).bestAll(library) {
+		if r.ok || isForcedPresent(library[i], cfg) {
+			continue
+		}
+		if err := fn(missingAlbum{library[i], r.nearest}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notInLibrary returns the RYM albums no library album matches, in list
+// order, leaving out ignored ones: the reverse of forEachMissing. The
+// matcher runs the other way round, matching against the prepared
+// library, whose albums are then tried under their album and track
+// artists but not their composers.
+func notInLibrary(library, rym []Album, cfg MatchConfig) []Album {
+	var out []Album
+	for i, r := range newMatcher(prepareLibrary(library, cfg), cfg).bestAll(rym) {
+		if !r.ok && !isIgnored(rym[i], cfg) {
+			out = append(out, rym[i])
+		}
+	}
+	return out
+}
+
+// scoredAlbum is a RYM album with the similarities of the library album
+// closest to it, whether or not that cleared the threshold.
+type scoredAlbum struct {
+	Album
+	Matched   bool    `json:"matched"`
+	MatchedID string  `json:"matched_id,omitempty"` // Jellyfin ID of the closest album
+	TitleSim  float64 `json:"title_similarity"`
+	ArtistSim float64 `json:"artist_similarity"`
+	Score     float64 `json:"score"`
+}
+
+// scoreRYM scores each RYM album against the library as notInLibrary
+// does. An unmatched album gets the closest pair the matcher scored for
+// it; one whose title resembles no library album keeps zero scores.
+func scoreRYM(library, rym []Album, cfg MatchConfig) []scoredAlbum {
+	m := newMatcher(prepareLibrary(library, cfg), cfg)
+	out := make([]scoredAlbum, len(rym))
+	for i, r := range m.bestAll(rym) {
+		match := r.match
+		if !r.ok {
+			match = r.nearest
+		}
+		out[i] = scoredAlbum{
+			Album: rym[i], Matched: r.ok, MatchedID: match.RYM.ID,
+			TitleSim: match.TitleSim, ArtistSim: match.ArtistSim, Score: match.Score,
+		}
+	}
+	return out
+}
+
+// findMatches returns the matched pairs whose confidence equals
+// confidence (any, if empty), shakiest first.
+func findMatches(library, rym []Album, cfg MatchConfig, confidence string) []Match {
+	var out []Match
+	for _, r := range newMatcher(rym, cfg).bestAll(prepareLibrary(library, cfg)) {
+		if !r.ok || (confidence != "" && r.match.Confidence != confidence) {
+			continue
+		}
+		out = append(out, r.match)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score < out[j].Score })
+	return out
+}
+
+// findTitleMatches pairs each Jellyfin album with the RYM album whose
+// title is most similar, whatever the artist, best first. It is meant
+// for exploring covers and tributes and will report plenty of pairs
+// that are not the same album.
+func findTitleMatches(library, rym []Album, cfg MatchConfig) []Match {
+	rymTitles := make([][]string, len(rym))
+	for i, a := range rym {
+		for _, t := range a.titles() {
+			rymTitles[i] = append(rymTitles[i], normalize(t, cfg.Title))
+		}
+	}
+
+	threshold := cfg.EffectiveThreshold()
+	var out []Match
+	for _, jfAlbum := range prepareLibrary(library, cfg) {
+		jfTitle := normalize(jfAlbum.Name, cfg.Title)
+		best := Match{TitleOnly: true}
+		found := false
+		for i, rymAlbum := range rym {
+			sim := 0.0
+			for _, t := range rymTitles[i] {
+				sim = max(sim, cfg.compare(jfTitle, t, threshold))
+			}
+			if sim > threshold && (!found || sim > best.TitleSim) {
+				best.RYM, best.TitleSim, found = rymAlbum, sim, true
+			}
+		}
+		if !found {
+			continue
+		}
+		best.Jellyfin = jfAlbum
+		best.Score = best.TitleSim
+		best.ArtistSim = cfg.compare(
+			cfg.artistKey(jfAlbum.AlbumArtist),
+			cfg.artistKey(best.RYM.AlbumArtist),
+			0,
+		)
+		out = append(out, best)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	return out
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestNotInLibraryTriesAltTitles(t *testing.T) {
+	library := []Album{{ID: "a", Name: "Kimi no Na wa", AlbumArtist: "RADWIMPS"}}
+	tests := []struct {
+		name string
+		rym  Album
+		want int // albums reported not in the library
+	}{
+		{"romanized title", Album{Name: "Kimi no Na wa", AlbumArtist: "RADWIMPS"}, 0},
+		{"localized title only matches", Album{Name: "君の名は。", AltTitles: []string{"Kimi no Na wa"}, AlbumArtist: "RADWIMPS"}, 0},
+		{"localized artist only matches", Album{Name: "Kimi no Na wa", AlbumArtist: "ラッドウィンプス", AltArtists: []string{"RADWIMPS"}}, 0},
+		{"nothing matches", Album{Name: "君の名は。", AlbumArtist: "RADWIMPS"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := notInLibrary(library, []Album{tt.rym}, DefaultMatchConfig())
+			if len(got) != tt.want {
+				t.Errorf("notInLibrary = %v, want %d albums", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSoundtrackArtistThreshold(t *testing.T) {
+	library := []Album{{ID: "a", Name: "Interstellar (Original Motion Picture Soundtrack)", AlbumArtist: "Hans Zimmer"}}
+	tests := []struct {
+		name   string
+		artist string
+		want   int // albums reported not in the library
+	}{
+		{"same composer", "Hans Zimmer", 0},
+		{"composer spelled differently", "Hans Zimmerman", 0},
+		{"someone else", "Various Artists", 1},
+	}
+	cfg := DefaultMatchConfig()
+	cfg.Soundtracks = true
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rym := []Album{{Name: "Interstellar (Original Motion Picture Soundtrack)", AlbumArtist: tt.artist}}
+			if got := notInLibrary(library, rym, cfg); len(got) != tt.want {
+				t.Errorf("notInLibrary = %v, want %d albums", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBestAllSameForAnyWorkers(t *testing.T) {
+	library, rym := syntheticLists(300, 300)
+	cfg := DefaultMatchConfig()
+	cfg.Workers = 1
+	want := newMatcher(rym, cfg).bestAll(library)
+	var wantMissing []Album
+	_ = forEachMissing(library, rym, cfg, func(m missingAlbum) error { wantMissing = append(wantMissing, m.Album); return nil })
+
+	for _, workers := range []int{2, 3, 8, 64} {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			cfg.Workers = workers
+			if got := newMatcher(rym, cfg).bestAll(library); !reflect.DeepEqual(got, want) {
+				t.Error("bestAll results differ from one worker's")
+			}
+			var missing []Album
+			_ = forEachMissing(library, rym, cfg, func(m missingAlbum) error { missing = append(missing, m.Album); return nil })
+			if !reflect.DeepEqual(missing, wantMissing) {
+				t.Errorf("%d missing, one worker finds %d", len(missing), len(wantMissing))
+			}
+		})
+	}
+}
+
+// BenchmarkBestAll diffs a synthetic 50,000 album library against a
+// list of 1,000 with different numbers of workers.
+func BenchmarkBestAll(b *testing.B) {
+	library, rym := syntheticLists(1000, 50000-750)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprint("workers=", workers), func(b *testing.B) {
+			cfg := DefaultMatchConfig()
+			cfg.Scoring, cfg.Threshold, cfg.Workers = ScoreBoth, 0.85, workers
+			for b.Loop() {
+				newMatcher(rym, cfg).bestAll(library)
+			}
+		})
+	}
+}
+
+func TestScoreRYMKeepsTheNearMiss(t *testing.T) {
+	library := []Album{{ID: "a", Name: "OK Computer", AlbumArtist: "Radiohead"}}
+	rym := []Album{
+		{Name: "OK Computer", AlbumArtist: "Radiohead"},
+		{Name: "OK Computer", AlbumArtist: "Blur"},
+		{Name: "Parklife", AlbumArtist: "Blur"},
+	}
+	got := scoreRYM(library, rym, DefaultMatchConfig())
+	if !got[0].Matched || got[0].Score != 1 {
+		t.Errorf("identical album = %+v, want matched with score 1", got[0])
+	}
+	if near := got[1]; near.Matched || near.MatchedID != "a" || near.TitleSim != 1 || near.Score == 0 {
+		t.Errorf("same title, other artist = %+v, want unmatched, scored against a", near)
+	}
+	if far := got[2]; far.Matched || far.MatchedID != "" || far.Score != 0 {
+		t.Errorf("nothing like it = %+v, want zero scores", far)
+	}
+}
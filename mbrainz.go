@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Jellyfin ProviderIds keys for MusicBrainz. RYM exports have no IDs,
+// but parseRymCSV fills the same keys from optional MBID columns.
+const (
+	providerMBRelease      = "MusicBrainzAlbum"
+	providerMBReleaseGroup = "MusicBrainzReleaseGroup"
+)
+
+// mbResolver resolves MusicBrainz release IDs to their release group,
+// remembering every answer, and keeps to MusicBrainz's limit of one
+// request per second. Lookups happen in Resolve, before matching; the
+// matcher only reads what it found.
+type mbResolver struct {
+	BaseURL   string // e.g. https://musicbrainz.org
+	HTTP      *http.Client
+	UserAgent string        // MusicBrainz asks for one identifying the app
+	Timeout   time.Duration // for all of one Resolve's lookups
+	Interval  time.Duration // between requests
+
+	mu     sync.Mutex
+	groups map[string]string // release ID -> group ID, "" if unknown
+	next   time.Time         // when the next request may be sent
+}
+
+var musicBrainz = &mbResolver{
+	BaseURL:   "https://musicbrainz.org",
+	HTTP:      &http.Client{Timeout: 10 * time.Second},
+	UserAgent: "rymcheck/1.0 (+https://github.com/tjugosex/rymcheck)",
+	Timeout:   time.Minute,
+	Interval:  time.Second,
+}
+
+// resolveReleaseGroups looks up the release groups of all the albums in
+// lists when cfg matches by release group, so the matcher finds them
+// already known.
+func resolveReleaseGroups(ctx context.Context, cfg MatchConfig, lists ...[]Album) {
+	if !cfg.ReleaseGroups {
+		return
+	}
+	for _, albums := range lists {
+		if err := musicBrainz.Resolve(ctx, albums); err != nil {
+			log.Printf("musicbrainz: %v; matching without the rest", err)
+			return
+		}
+	}
+}
+
+// Resolve looks up the release group of every album that has a release
+// ID but no group and hasn't been looked up before. It gives up after
+// Timeout or when ctx is done; albums it didn't get to are matched as if
+// they had no release ID.
+func (r *mbResolver) Resolve(ctx context.Context, albums []Album) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+	for _, a := range albums {
+		release := a.ProviderIDs[providerMBRelease]
+		if release == "" || a.ProviderIDs[providerMBReleaseGroup] != "" {
+			continue
+		}
+		if _, ok := r.cached(release); ok {
+			continue
+		}
+		if _, err := r.lookup(ctx, release); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("musicbrainz: release %s: %v", release, err)
+		}
+	}
+	return nil
+}
+
+// releaseGroup returns the MusicBrainz release group of a, taken from
+// its provider IDs or else from what Resolve found for its release ID,
+// or "" if there is neither. It never makes a request.
+func (r *mbResolver) releaseGroup(a Album) string {
+	if g := a.ProviderIDs[providerMBReleaseGroup]; g != "" {
+		return g
+	}
+	g, _ := r.cached(a.ProviderIDs[providerMBRelease])
+	return g
+}
+
+func (r *mbResolver) cached(release string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	g, ok := r.groups[release]
+	return g, ok
+}
+
+// wait blocks until the next request slot, which it takes, or until ctx
+// is done.
+func (r *mbResolver) wait(ctx context.Context) error {
+	r.mu.Lock()
+	now := time.Now()
+	at := r.next
+	if at.Before(now) {
+		at = now
+	}
+	r.next = at.Add(r.Interval)
+	r.mu.Unlock()
+
+	t := time.NewTimer(at.Sub(now))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// lookup asks MusicBrainz for the release group of a release. Failures
+// other than an unknown release are not cached, so they are retried.
+func (r *mbResolver) lookup(ctx context.Context, release string) (string, error) {
+	if err := r.wait(ctx); err != nil {
+		return "", err
+	}
+	u := r.BaseURL + "/ws/2/release/" + url.PathEscape(release) + "?inc=release-groups&fmt=json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", r.UserAgent)
+	req.Header.Set("Accept", "application/json")
+	resp, err := r.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var group string
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var body struct {
+			ReleaseGroup struct {
+				ID string `json:"id"`
+			} `json:"release-group"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return "", err
+		}
+		group = body.ReleaseGroup.ID
+	case http.StatusNotFound:
+	default:
+		return "", fmt.Errorf("bad status %d", resp.StatusCode)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.groups == nil {
+		r.groups = make(map[string]string)
+	}
+	r.groups[release] = group
+	return group, nil
+}
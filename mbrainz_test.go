@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeMusicBrainz serves release lookups: release "r1" is in group
+// "g1", "gone" is unknown and anything else fails.
+func fakeMusicBrainz(t *testing.T, requests *atomic.Int32) *mbResolver {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch strings.TrimPrefix(r.URL.Path, "/ws/2/release/") {
+		case "r1":
+			w.Write([]byte(`{"release-group": {"id": "g1"}}`))
+		case "gone":
+			http.NotFound(w, r)
+		default:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return &mbResolver{BaseURL: srv.URL, HTTP: srv.Client(), Timeout: time.Second, Interval: time.Millisecond}
+}
+
+func withRelease(release, group string) Album {
+	ids := map[string]string{providerMBRelease: release}
+	if group != "" {
+		ids[providerMBReleaseGroup] = group
+	}
+	return Album{ProviderIDs: ids}
+}
+
+func TestMBResolverResolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		album    Album
+		want     string
+		requests int32 // made by two Resolves in a row
+	}{
+		{"looked up", withRelease("r1", ""), "g1", 1},
+		{"group already known", withRelease("r1", "g9"), "g9", 0},
+		{"unknown release is cached", withRelease("gone", ""), "", 1},
+		{"failure is retried", withRelease("broken", ""), "", 2},
+		{"no release ID", Album{}, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			mb := fakeMusicBrainz(t, &requests)
+			for range 2 {
+				if err := mb.Resolve(context.Background(), []Album{tt.album}); err != nil {
+					t.Fatalf("Resolve: %v", err)
+				}
+			}
+			if got := mb.releaseGroup(tt.album); got != tt.want {
+				t.Errorf("releaseGroup = %q, want %q", got, tt.want)
+			}
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("made %d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestMBResolverResolveStopsWhenCancelled(t *testing.T) {
+	var requests atomic.Int32
+	mb := fakeMusicBrainz(t, &requests)
+	mb.Interval = time.Hour
+	albums := []Album{withRelease("r1", ""), withRelease("r2", "")}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- mb.Resolve(ctx, albums) }()
+	// The second lookup waits for its slot; meanwhile the cache stays
+	// readable.
+	for requests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	mb.releaseGroup(albums[0])
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Resolve = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Resolve didn't stop when cancelled")
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("made %d requests, want 1", got)
+	}
+}
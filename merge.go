@@ -0,0 +1,99 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// discSuffix matches a trailing disc marker such as "(Disc 1)", "[CD 2]",
+// " - Disc Two" or "(Disc 1 of 2)".
+var discSuffix = regexp.MustCompile(`(?i)\s*[-–:,]?\s*[(\[]?\s*\b(?:disc|disk|cd)\s*(?:\d+|one|two|three|four|five|six|seven|eight|nine|ten)(?:\s*(?:of|/)\s*\d+)?\s*[)\]]?\s*$`)
+
+// discBaseTitle strips a trailing disc marker from title. It reports
+// false if there was none, or if nothing would be left of the title.
+func discBaseTitle(title string) (string, bool) {
+	loc := discSuffix.FindStringIndex(title)
+	if loc == nil {
+		return title, false
+	}
+	base := strings.TrimSpace(title[:loc[0]])
+	if base == "" {
+		return title, false
+	}
+	return base, true
+}
+
+// editionTag matches a trailing parenthetical or bracketed qualifier, or
+// a " - " suffix, that edition vocabulary is then looked for in.
+var editionTag = regexp.MustCompile(`\s*(?:[(\[]([^()\[\]]*)[)\]]|\s[-–]\s+([^-–]+))\s*$`)
+
+// editionWords marks a qualifier as naming an edition rather than being
+// part of the title. "Version" is deliberately absent: "(Taylor's
+// Version)" is a different recording, not an edition.
+var editionWords = regexp.MustCompile(`(?i)\b(?:remaster(?:ed)?|deluxe|expanded|anniversary|edition|explicit|clean|mono|stereo|bonus|reissue|special|collector'?s|legacy|super)\b`)
+
+// editionBaseTitle strips trailing edition qualifiers such as
+// "(Deluxe Edition)", "[2011 Remaster]" or " - Remastered 2009" from
+// title, repeatedly. It reports false if there were none.
+func editionBaseTitle(title string) (string, bool) {
+	base, stripped := title, false
+	for {
+		m := editionTag.FindStringSubmatchIndex(base)
+		if m == nil {
+			break
+		}
+		inner := ""
+		if m[2] >= 0 {
+			inner = base[m[2]:m[3]]
+		} else {
+			inner = base[m[4]:m[5]]
+		}
+		rest := strings.TrimSpace(base[:m[0]])
+		if !editionWords.MatchString(inner) || rest == "" {
+			break
+		}
+		base, stripped = rest, true
+	}
+	return base, stripped
+}
+
+// collapseDiscs merges albums by the same artist whose titles differ only
+// by a disc marker; see mergeByBase.
+func collapseDiscs(library []Album, cfg MatchConfig) []Album {
+	return mergeByBase(library, cfg, discBaseTitle)
+}
+
+// mergeEditions merges the editions of an album, like "OK Computer" and
+// "OK Computer (Deluxe Edition)", into one; see mergeByBase.
+func mergeEditions(library []Album, cfg MatchConfig) []Album {
+	return mergeByBase(library, cfg, editionBaseTitle)
+}
+
+// mergeByBase folds albums by the same artist that reduce to the same
+// title under base into the first of them, renamed to the base title
+// and listing the original names in Merged. An album base leaves alone
+// only absorbs ones base did change, so two plain duplicates stay apart.
+// The library order is kept.
+func mergeByBase(library []Album, cfg MatchConfig, base func(string) (string, bool)) []Album {
+	out := make([]Album, 0, len(library))
+	seen := make(map[string]int) // artist + base title -> index in out
+	for _, a := range library {
+		title, changed := base(a.Name)
+		key := cfg.artistKey(a.AlbumArtist) + "\x00" + normalize(title, cfg.Title)
+		if i, ok := seen[key]; ok && (changed || len(out[i].Merged) > 0) {
+			if len(out[i].Merged) == 0 {
+				out[i].Merged = []string{out[i].Name}
+			}
+			out[i].Merged = append(out[i].Merged, a.Name)
+			continue
+		}
+		if _, ok := seen[key]; !ok {
+			seen[key] = len(out)
+		}
+		if changed {
+			a.Name, a.Merged = title, []string{a.Name}
+		}
+		out = append(out, a)
+	}
+	return out
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiscBaseTitle(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"The Wall (Disc 1)", "The Wall", true},
+		{"The Wall [CD 2]", "The Wall", true},
+		{"The Wall - Disc Two", "The Wall", true},
+		{"The Wall (Disc 1 of 2)", "The Wall", true},
+		{"The Wall: disk 2/2", "The Wall", true},
+		{"The Wall", "The Wall", false},
+		{"Disc 1", "Disc 1", false}, // nothing left
+		{"Discovery", "Discovery", false},
+		{"CD2 Remixes", "CD2 Remixes", false}, // not trailing
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, ok := discBaseTitle(tt.in)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("discBaseTitle(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCollapseDiscs(t *testing.T) {
+	tests := []struct {
+		name    string
+		library []Album
+		want    []string // names after collapsing
+	}{
+		{
+			"two discs",
+			[]Album{{Name: "The Wall (Disc 1)", AlbumArtist: "Pink Floyd"}, {Name: "The Wall (Disc 2)", AlbumArtist: "Pink Floyd"}},
+			[]string{"The Wall"},
+		},
+		{
+			"mixed markers",
+			[]Album{{Name: "The Wall [CD 1]", AlbumArtist: "Pink Floyd"}, {Name: "The Wall - Disc Two", AlbumArtist: "Pink Floyd"}, {Name: "Animals", AlbumArtist: "Pink Floyd"}},
+			[]string{"The Wall", "Animals"},
+		},
+		{
+			"parent and disc",
+			[]Album{{Name: "The Wall", AlbumArtist: "Pink Floyd"}, {Name: "The Wall (Disc 2)", AlbumArtist: "Pink Floyd"}},
+			[]string{"The Wall"},
+		},
+		{
+			"other artists stay apart",
+			[]Album{{Name: "Greatest Hits (Disc 1)", AlbumArtist: "Queen"}, {Name: "Greatest Hits (Disc 1)", AlbumArtist: "ABBA"}},
+			[]string{"Greatest Hits", "Greatest Hits"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, a := range collapseDiscs(tt.library, DefaultMatchConfig()) {
+				got = append(got, a.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("collapsed to %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollapsedDiscsMissOnce(t *testing.T) {
+	library := []Album{{ID: "1", Name: "The Wall (Disc 1)", AlbumArtist: "Pink Floyd"}, {ID: "2", Name: "The Wall (Disc 2)", AlbumArtist: "Pink Floyd"}}
+	tests := []struct {
+		name     string
+		collapse bool
+		rym      []Album
+		want     int // albums missing
+	}{
+		{"on the list", true, []Album{{Name: "The Wall", AlbumArtist: "Pink Floyd"}}, 0},
+		{"not on the list", true, []Album{{Name: "Animals", AlbumArtist: "Pink Floyd"}}, 1},
+		{"not on the list, not collapsed", false, []Album{{Name: "Animals", AlbumArtist: "Pink Floyd"}}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultMatchConfig()
+			cfg.CollapseDiscs = tt.collapse
+			got := 0
+			_ = forEachMissing(library, tt.rym, cfg, func(missingAlbum) error { got++; return nil })
+			if got != tt.want {
+				t.Errorf("%d missing, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"strings"
+)
+
+// Match modes: how two normalized strings are scored.
+const (
+	// ModeLevenshtein scores by edit distance over the whole string. It
+	// suits typos and small spelling differences.
+	ModeLevenshtein = "levenshtein"
+	// ModeTokenSet scores by the share of words the strings have in
+	// common, ignoring their order, so "Davis Miles" matches "Miles
+	// Davis". One differing word costs a lot in a short title.
+	ModeTokenSet = "token_set"
+	// ModePhonetic compares the Soundex codes of the words by edit
+	// distance, so names that sound alike match. The codes are short,
+	// so unrelated words collide more and a higher threshold is needed.
+	ModePhonetic = "phonetic"
+	// ModeCombined takes the better of the Levenshtein and token set
+	// scores, so reordered words match without losing typo tolerance.
+	ModeCombined = "combined"
+	// ModeJaroWinkler scores by Jaro-Winkler similarity, which forgives a
+	// character or two in a short name more than edit distance does and
+	// favours strings sharing a prefix, like "GZA" and "GZA/Genius".
+	ModeJaroWinkler = "jaro_winkler"
+)
+
+// modeThresholds holds the recommended threshold of each match mode,
+// used when MatchConfig.Threshold is left at zero.
+var modeThresholds = map[string]float64{
+	ModeLevenshtein: 0.75,
+	ModeTokenSet:    0.5,
+	ModePhonetic:    0.85,
+	ModeCombined:    0.75,
+	ModeJaroWinkler: 0.85,
+}
+
+// mode returns the configured match mode, ModeLevenshtein if none is.
+func (c MatchConfig) mode() string {
+	if c.Mode == "" {
+		return ModeLevenshtein
+	}
+	return c.Mode
+}
+
+// EffectiveThreshold returns Threshold, or the mode's recommended one if
+// Threshold is zero.
+func (c MatchConfig) EffectiveThreshold() float64 {
+	if c.Threshold > 0 {
+		return c.Threshold
+	}
+	return modeThresholds[c.mode()]
+}
+
+// compare scores two normalized strings in c's mode. Levenshtein scores
+// at or below threshold may come back as 0; see similarityAbove.
+func (c MatchConfig) compare(a, b string, threshold float64) float64 {
+	switch c.mode() {
+	case ModeTokenSet:
+		if c.MinTokenOverlap > 0 && !enoughOverlap(a, b, c.MinTokenOverlap) {
+			return 0
+		}
+		return jaccardSimilarity(a, b)
+	case ModePhonetic:
+		return similarity(phoneticKey(a), phoneticKey(b))
+	case ModeCombined:
+		sim := similarityAbove(a, b, threshold, c.MaxDistance)
+		if c.MinTokenOverlap == 0 || enoughOverlap(a, b, c.MinTokenOverlap) {
+			sim = max(sim, jaccardSimilarity(a, b))
+		}
+		return sim
+	case ModeJaroWinkler:
+		return jaroWinkler(a, b)
+	default:
+		return similarityAbove(a, b, threshold, c.MaxDistance)
+	}
+}
+
+// jaroWinkler returns the Jaro similarity of a and b, by rune, raised by
+// a tenth of the remainder for each of up to four leading runes they
+// share.
+func jaroWinkler(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+	if len(ra) > len(rb) {
+		ra, rb = rb, ra
+	}
+	window := max(len(rb)/2-1, 0)
+	used := make([]bool, len(rb))
+	var matchedA []rune
+	for i, r := range ra {
+		for j := max(i-window, 0); j <= min(i+window, len(rb)-1); j++ {
+			if !used[j] && rb[j] == r {
+				used[j] = true
+				matchedA = append(matchedA, r)
+				break
+			}
+		}
+	}
+	m := len(matchedA)
+	if m == 0 {
+		return 0
+	}
+	transposed, k := 0, 0
+	for j, r := range rb {
+		if used[j] {
+			if r != matchedA[k] {
+				transposed++
+			}
+			k++
+		}
+	}
+	fm := float64(m)
+	jaro := (fm/float64(len(ra)) + fm/float64(len(rb)) + (fm-float64(transposed/2))/fm) / 3
+
+	prefix := 0
+	for prefix < min(4, len(ra)) && ra[prefix] == rb[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+// jaccardSimilarity is the number of distinct words a and b share over
+// the number of distinct words in either.
+func jaccardSimilarity(a, b string) float64 {
+	wa, wb := strings.Fields(a), strings.Fields(b)
+	if len(wa) == 0 && len(wb) == 0 {
+		return 1
+	}
+	set := make(map[string]int, len(wa)) // 1: in a, 3: in both
+	for _, w := range wa {
+		set[w] = 1
+	}
+	union := len(set)
+	shared := 0
+	for _, w := range wb {
+		switch set[w] {
+		case 0:
+			union++
+			set[w] = 2
+		case 1:
+			shared++
+			set[w] = 3
+		}
+	}
+	return float64(shared) / float64(union)
+}
+
+// tokenStopwords are words too common in titles and names to count
+// towards MinTokenOverlap.
+var tokenStopwords = map[string]bool{
+	"a": true, "an": true, "the": true, "and": true, "or": true,
+	"of": true, "in": true, "on": true, "at": true, "to": true, "for": true,
+	"de": true, "la": true, "le": true, "el": true, "die": true, "der": true,
+	"live": true, "volume": true, "part": true, "number": true,
+}
+
+// enoughOverlap reports whether a and b share at least n significant
+// words, or all of them if the side with fewer has less than n. Strings
+// without significant words always pass; there is nothing to require.
+func enoughOverlap(a, b string, n int) bool {
+	significant := func(s string) map[string]bool {
+		set := make(map[string]bool)
+		for _, w := range strings.Fields(s) {
+			if !tokenStopwords[w] {
+				set[w] = true
+			}
+		}
+		return set
+	}
+	sa, sb := significant(a), significant(b)
+	need := min(n, len(sa), len(sb))
+	if need == 0 {
+		return true
+	}
+	shared := 0
+	for w := range sa {
+		if sb[w] {
+			shared++
+		}
+	}
+	return shared >= need
+}
+
+// phoneticKey replaces each word of a normalized string by its Soundex
+// code. Words without Latin letters, which Soundex can't encode, are
+// kept as they are.
+func phoneticKey(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		if code := soundex(w); code != "" {
+			words[i] = code
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// soundexCodes maps the consonants Soundex encodes to their digit.
+var soundexCodes = map[rune]byte{
+	'b': '1', 'f': '1', 'p': '1', 'v': '1',
+	'c': '2', 'g': '2', 'j': '2', 'k': '2', 'q': '2', 's': '2', 'x': '2', 'z': '2',
+	'd': '3', 't': '3',
+	'l': '4',
+	'm': '5', 'n': '5',
+	'r': '6',
+}
+
+// soundex returns the American Soundex code of a lowercase word, like
+// "r163" for "robert", or "" if it has no letters a to z. A word with a
+// digit in it is returned unchanged, numbers being no sound to blur.
+func soundex(w string) string {
+	var out []byte
+	var last byte // code of the previous letter; h and w don't reset it
+	for _, r := range w {
+		if r >= '0' && r <= '9' {
+			return w
+		}
+		if r < 'a' || r > 'z' {
+			continue
+		}
+		code := soundexCodes[r]
+		if len(out) == 0 {
+			out, last = append(out, byte(r)), code
+			continue
+		}
+		if code != 0 && code != last && len(out) < 4 {
+			out = append(out, code)
+		}
+		if r != 'h' && r != 'w' {
+			last = code
+		}
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	for len(out) < 4 {
+		out = append(out, '0')
+	}
+	return string(out)
+}
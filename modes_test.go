@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEnoughOverlap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		n    int
+		want bool
+	}{
+		{"live at wembley", "live in berlin", 1, false}, // only stopwords shared
+		{"the wall", "the final cut", 1, false},
+		{"the wall", "wall", 1, true},
+		{"greatest hits volume 1", "greatest hits volume 2", 2, true},
+		{"greatest hits", "greatest misses", 2, false},
+		{"blue", "blue lines", 2, true}, // the shorter side has only one word
+		{"the", "a live", 1, true},      // no significant words at all
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := enoughOverlap(tt.a, tt.b, tt.n); got != tt.want {
+				t.Errorf("enoughOverlap(%q, %q, %d) = %v, want %v", tt.a, tt.b, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenSetMinOverlap(t *testing.T) {
+	tests := []struct {
+		name       string
+		a, b       string
+		minOverlap int
+		rejected   bool
+	}{
+		{"common word only, no minimum", "live at wembley", "live in berlin", 0, false},
+		{"common word only", "live at wembley", "live in berlin", 1, true},
+		{"article only", "the wall", "the river", 1, true},
+		{"significant word shared", "live at wembley", "wembley live", 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultMatchConfig()
+			cfg.Mode, cfg.MinTokenOverlap = ModeTokenSet, tt.minOverlap
+			if got := cfg.compare(tt.a, tt.b, 0); (got == 0) != tt.rejected {
+				t.Errorf("compare(%q, %q) = %v, want rejected %v", tt.a, tt.b, got, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestJaroWinkler(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"martha", "marhta", 0.9611},
+		{"dwayne", "duane", 0.84},
+		{"dixon", "dicksonx", 0.8133},
+		{"gza", "gza", 1},
+		{"abc", "xyz", 0},
+		{"", "gza", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := jaroWinkler(tt.a, tt.b); math.Abs(got-tt.want) > 0.0001 {
+				t.Errorf("jaroWinkler(%q, %q) = %.4f, want %.4f", tt.a, tt.b, got, tt.want)
+			}
+			if got := jaroWinkler(tt.b, tt.a); math.Abs(got-tt.want) > 0.0001 {
+				t.Errorf("jaroWinkler(%q, %q) = %.4f, not symmetric", tt.b, tt.a, got)
+			}
+		})
+	}
+}
+
+// TestJaroWinklerAgainstLevenshtein compares the two metrics on short
+// names, where Jaro-Winkler's prefix bonus should keep pairs that
+// edit distance, normalized by length, drops.
+func TestJaroWinklerAgainstLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"gza", "gza genius"},
+		{"mf doom", "mf d00m"},
+		{"bjork", "bjrk"},
+		{"nas", "nasir"},
+	}
+	const threshold = 0.8
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			jw, lev := jaroWinkler(tt.a, tt.b), similarity(tt.a, tt.b)
+			if jw <= lev {
+				t.Errorf("Jaro-Winkler %.3f not above Levenshtein %.3f", jw, lev)
+			}
+			if jw <= threshold {
+				t.Errorf("Jaro-Winkler %.3f doesn't clear %.2f", jw, threshold)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// qgramSize is the gram length used by qgramIndex.
+const qgramSize = 3
+
+// qgramIndex is an inverted index from the trigrams of each RYM album's
+// normalized "artist title" keys to the albums containing them. It lets
+// a lookup skip the Levenshtein comparison for albums that share too few
+// trigrams to possibly clear the threshold. An album has one key per
+// artist it is credited to and title it goes by, so split releases are
+// found under each artist and localized titles under each title.
+type qgramIndex struct {
+	entries  []int // key number -> index into the RYM list
+	postings map[string][]qgramPosting
+}
+
+type qgramPosting struct {
+	entry int // index into entries
+	count int // occurrences of the gram in that key
+}
+
+// newQGramIndex indexes the RYM albums with the given keys for matching
+// with cfg. It fails, rather than returning an index that would drop
+// true matches, when cfg's mode isn't edit distance, which the bound
+// relies on, or when indexing panics.
+func newQGramIndex(rym []albumKeys, cfg MatchConfig) (ix *qgramIndex, err error) {
+	if cfg.mode() != ModeLevenshtein {
+		return nil, fmt.Errorf("the q-gram bound does not hold in %s mode", cfg.mode())
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			ix, err = nil, fmt.Errorf("building index: %v", r)
+		}
+	}()
+	ix = &qgramIndex{postings: make(map[string][]qgramPosting)}
+	for i, k := range rym {
+		for _, title := range k.titles {
+			for _, artist := range k.artists {
+				key := qgramKey(artist, title)
+				for g, n := range indexedQGrams(key) {
+					ix.postings[g] = append(ix.postings[g], qgramPosting{entry: len(ix.entries), count: n})
+				}
+				ix.entries = append(ix.entries, i)
+			}
+		}
+	}
+	return ix, nil
+}
+
+// qgramPrunes reports whether candidates can filter anything when both
+// similarities need only exceed cutoff. At q/(q+1) or below, the edits
+// that allows cover about every gram of the key. Weighted scoring, whose
+// per-field cutoffs are low, usually ends up there.
+func qgramPrunes(cutoff float64) bool {
+	return cutoff > float64(qgramSize)/(qgramSize+1)
+}
+
+// candidates returns, in ascending order, the indices of the RYM albums
+// that may match an album with the given normalized title and any of the
+// normalized artists at threshold.
+//
+// The bound is the q-gram count filter: strings at edit distance k share
+// at least len-q+1-k*q grams. Both similarities exceeding t caps each
+// field's distance below (1-t)/t times its length, and the distance of
+// the joined key is at most the sum of the two. When that leaves nothing
+// to filter on, every album is a candidate and ok is false.
+func (ix *qgramIndex) candidates(artists []string, title string, threshold float64) (idx []int, ok bool) {
+	if threshold <= 0 {
+		return nil, false
+	}
+	slack := (1 - threshold) / threshold
+	keep := make(map[int]bool)
+	for _, artist := range artists {
+		key := qgramKey(artist, title)
+		k := int(slack*float64(len([]rune(artist)))) + int(slack*float64(len([]rune(title))))
+		need := len([]rune(key)) - qgramSize + 1 - k*qgramSize
+		if need <= 0 {
+			return nil, false
+		}
+
+		shared := make(map[int]int)
+		for g, n := range qgrams(key) {
+			for _, p := range ix.postings[g] {
+				shared[p.entry] += min(n, p.count)
+			}
+		}
+		for e, n := range shared {
+			if n >= need {
+				keep[ix.entries[e]] = true
+			}
+		}
+	}
+	for i := range keep {
+		idx = append(idx, i)
+	}
+	slices.Sort(idx)
+	return idx, true
+}
+
+func qgramKey(artist, title string) string {
+	return artist + " " + title
+}
+
+// indexedQGrams is what newQGramIndex counts the grams of keys with;
+// tests replace it to make building fail.
+var indexedQGrams = qgrams
+
+// qgrams counts the q-grams of s, by rune.
+func qgrams(s string) map[string]int {
+	r := []rune(s)
+	out := make(map[string]int)
+	for i := 0; i+qgramSize <= len(r); i++ {
+		out[string(r[i:i+qgramSize])]++
+	}
+	return out
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// syntheticLists returns a RYM list of n albums and a library holding
+// three quarters of them, some misspelled, and extra albums of its own.
+func syntheticLists(n, extra int) (library, rym []Album) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	syllables := []string{"ka", "lo", "mir", "then", "dra", "vo", "su", "nel", "bri", "ost", "ja", "quen"}
+	word := func() string {
+		w := ""
+		for range 2 + rnd.IntN(2) {
+			w += syllables[rnd.IntN(len(syllables))]
+		}
+		return w
+	}
+	name := func(words int) string {
+		s := word()
+		for range words - 1 {
+			s += " " + word()
+		}
+		return s
+	}
+	typo := func(s string) string {
+		r := []rune(s)
+		i := rnd.IntN(len(r))
+		return string(r[:i]) + string(r[i+1:])
+	}
+	for i := range n {
+		a := Album{Name: name(1 + rnd.IntN(3)), AlbumArtist: name(1 + rnd.IntN(2)), ProductionYear: 1960 + rnd.IntN(60)}
+		rym = append(rym, a)
+		switch rnd.IntN(4) {
+		case 0:
+			a.Name = typo(a.Name)
+		case 1:
+			a.AlbumArtist = typo(a.AlbumArtist)
+		case 2:
+			continue // not in the library
+		}
+		a.ID = fmt.Sprint("owned", i)
+		library = append(library, a)
+	}
+	for i := range extra {
+		library = append(library, Album{ID: fmt.Sprint("other", i), Name: name(2), AlbumArtist: name(1), ProductionYear: 1990})
+	}
+	return library, rym
+}
+
+func TestQGramIndexFindsTheSameMatches(t *testing.T) {
+	library, rym := syntheticLists(400, 200)
+	tests := []struct {
+		name      string
+		scoring   string
+		threshold float64
+	}{
+		{"both fields", ScoreBoth, 0.8},
+		{"both fields, strict", ScoreBoth, 0.9},
+		{"weighted, strict", ScoreWeighted, 0.95},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultMatchConfig()
+			cfg.Scoring, cfg.Threshold = tt.scoring, tt.threshold
+			naive := newMatcher(rym, cfg).bestAll(library)
+			cfg.QGramIndex = true
+			m := newMatcher(rym, cfg)
+			if m.index == nil {
+				t.Fatal("no index built")
+			}
+			indexed := m.bestAll(library)
+			matched := 0
+			for i := range library {
+				// The near misses depend on what each one prunes.
+				got, want := indexed[i], naive[i]
+				if got.ok != want.ok || !reflect.DeepEqual(got.match, want.match) {
+					t.Errorf("%s by %s: indexed %+v, naive %+v", library[i].Name, library[i].AlbumArtist, got.match, want.match)
+				}
+				if naive[i].ok {
+					matched++
+				}
+			}
+			if matched == 0 {
+				t.Error("nothing matched, so nothing was compared")
+			}
+		})
+	}
+}
+
+func TestQGramIndexOnlyBuiltWhenItPrunes(t *testing.T) {
+	_, rym := syntheticLists(10, 5)
+	tests := []struct {
+		name      string
+		mode      string
+		scoring   string
+		threshold float64
+		want      bool
+	}{
+		{"both fields", ModeLevenshtein, ScoreBoth, 0.8, true},
+		{"weighted", ModeLevenshtein, ScoreWeighted, 0.8, false},
+		{"low threshold", ModeLevenshtein, ScoreBoth, 0.7, false},
+		{"token set", ModeTokenSet, ScoreBoth, 0.8, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultMatchConfig()
+			cfg.QGramIndex = true
+			cfg.Mode, cfg.Scoring, cfg.Threshold = tt.mode, tt.scoring, tt.threshold
+			if got := newMatcher(rym, cfg).index != nil; got != tt.want {
+				t.Errorf("index built = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkQGramIndex(b *testing.B) {
+	library, rym := syntheticLists(2000, 1000)
+	for _, indexed := range []bool{false, true} {
+		b.Run(fmt.Sprintf("indexed=%v", indexed), func(b *testing.B) {
+			cfg := DefaultMatchConfig()
+			cfg.Scoring, cfg.Threshold, cfg.QGramIndex = ScoreBoth, 0.85, indexed
+			for b.Loop() {
+				newMatcher(rym, cfg).bestAll(library)
+			}
+		})
+	}
+}
+
+func TestQGramIndexFailureFallsBack(t *testing.T) {
+	library, rym := syntheticLists(200, 100)
+	cfg := DefaultMatchConfig()
+	cfg.Scoring, cfg.Threshold = ScoreBoth, 0.8
+	want := newMatcher(rym, cfg).bestAll(library)
+
+	tests := []struct {
+		name   string
+		qgrams func(string) map[string]int
+	}{
+		{"panics", func(string) map[string]int { panic("index bug") }},
+		{"runtime error", func(string) map[string]int { var m map[string]int; m["x"]++; return m }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs strings.Builder
+			log.SetOutput(&logs)
+			indexedQGrams = tt.qgrams
+			t.Cleanup(func() { log.SetOutput(os.Stderr); indexedQGrams = qgrams })
+
+			cfg := cfg
+			cfg.QGramIndex = true
+			m := newMatcher(rym, cfg)
+			if m.index != nil {
+				t.Fatal("index built despite failing")
+			}
+			if !strings.Contains(logs.String(), "comparing all pairs") {
+				t.Errorf("no warning logged, got %q", logs.String())
+			}
+			if got := m.bestAll(library); !reflect.DeepEqual(got, want) {
+				t.Error("fallback results differ from matching without an index")
+			}
+		})
+	}
+}
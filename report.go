@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// Statuses of a library album in the combined report.
+const (
+	reportMatched       = "matched"
+	reportTentative     = "tentative" // matched by the second pass only
+	reportMissing       = "missing"
+	reportForcedPresent = "forced_present"
+)
+
+// reportRow is one library album in the combined report: its status
+// and, unless missing, the RYM album it matched and how well, under the
+// names Match uses.
+type reportRow struct {
+	Status     string  `json:"status"`
+	Jellyfin   Album   `json:"jellyfin"`
+	RYM        *Album  `json:"rym,omitempty"`
+	TitleSim   float64 `json:"title_similarity,omitempty"`
+	ArtistSim  float64 `json:"artist_similarity,omitempty"`
+	Score      float64 `json:"score,omitempty"`
+	Confidence string  `json:"confidence,omitempty"`
+}
+
+// combinedReport annotates every library album, in library order, with
+// whether it is on rym.
+func combinedReport(library, rym []Album, cfg MatchConfig) []reportRow {
+	library = prepareLibrary(library, cfg)
+	out := make([]reportRow, 0, len(library))
+	for i, r := range newMatcher(rym, cfg).bestAll(library) {
+		row := reportRow{Status: reportMissing, Jellyfin: library[i]}
+		m := r.match
+		switch {
+		case r.ok:
+			row.Status = reportMatched
+			if m.Tentative {
+				row.Status = reportTentative
+			}
+			row.RYM = &m.RYM
+			row.TitleSim, row.ArtistSim, row.Score, row.Confidence = m.TitleSim, m.ArtistSim, m.Score, m.Confidence
+		case isForcedPresent(library[i], cfg):
+			row.Status = reportForcedPresent
+		}
+		out = append(out, row)
+	}
+	return out
+}
+
+// writeReportCSV writes rows as CSV with a header, the RYM columns left
+// empty for albums without a match.
+func writeReportCSV(w io.Writer, rows []reportRow) error {
+	cw := csv.NewWriter(w)
+	header := []string{
+		"status", "artist", "title", "year", "id",
+		"rym_artist", "rym_title", "rym_year", "rym_id",
+		"title_similarity", "artist_similarity", "score", "confidence",
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	year := func(y int) string {
+		if y == 0 {
+			return ""
+		}
+		return strconv.Itoa(y)
+	}
+	score := func(f float64) string { return strconv.FormatFloat(f, 'f', 3, 64) }
+	for _, r := range rows {
+		a := r.Jellyfin
+		row := []string{r.Status, a.AlbumArtist, a.Name, year(a.Year()), a.ID, "", "", "", "", "", "", "", ""}
+		if m := r.RYM; m != nil {
+			copy(row[5:], []string{m.AlbumArtist, m.Name, year(m.Year()), m.RYMAlbumID, score(r.TitleSim), score(r.ArtistSim), score(r.Score), r.Confidence})
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
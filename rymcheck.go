@@ -1,26 +1,44 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"cmp"
+	"compress/gzip"
 	"context"
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
+	"maps"
+	"math/rand/v2"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/texttheater/golang-levenshtein/levenshtein"
+	textunicode "golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
 	"golang.org/x/text/unicode/norm"
 )
 
@@ -32,6 +50,141 @@ type Album struct {
 	ProductionYear  int    `json:"ProductionYear"`
 	Overview        string `json:"Overview"`
 	PrimaryImageTag string `json:"PrimaryImageTag"`
+
+	// ReleaseDate, for RYM albums, is as precise as RYM knows it: a bare
+	// year is stored as January 1st of it, a month as its first day.
+	ReleaseDate time.Time `json:"release_date,omitzero"`
+
+	// AltTitles are further titles a RYM album is matched under, from
+	// the title columns after the first (see csvOptions.TitleColumns).
+	AltTitles []string `json:"alt_titles,omitempty"`
+
+	// AltArtists are further names a RYM album's artist is matched under:
+	// the localized name, when the export has one that differs.
+	AltArtists []string `json:"alt_artists,omitempty"`
+
+	// RYM genres and descriptors, when the export has those columns.
+	Genres      []string `json:"genres,omitempty"`
+	Descriptors []string `json:"descriptors,omitempty"`
+
+	// Merged holds the original names of library albums folded into
+	// this one, e.g. the individual discs of a multi-disc set.
+	Merged []string `json:"merged,omitempty"`
+
+	// AlbumArtists are Jellyfin's album artists one by one. For
+	// collaborations AlbumArtist may be blank even though these aren't;
+	// see fillAlbumArtist.
+	AlbumArtists []NameID `json:"AlbumArtists,omitempty"`
+
+	// Per-user play state; only present for Jellyfin albums.
+	UserData *UserData `json:"UserData,omitempty"`
+
+	// Track artists and credited people, for albums whose AlbumArtist
+	// is not the name RYM files them under (e.g. classical composers).
+	// For RYM split releases, Artists holds each artist of the split.
+	Artists []string `json:"Artists,omitempty"`
+	People  []Person `json:"People,omitempty"`
+
+	// BadYear marks a library album whose ProductionYear is implausible
+	// under the match config, most likely a tagging error.
+	BadYear bool `json:"bad_year,omitempty"`
+
+	// External IDs, e.g. MusicBrainz ones; see providerMBRelease.
+	ProviderIDs map[string]string `json:"ProviderIds,omitempty"`
+}
+
+// Year returns the album's year, or 0 if it is unknown or flagged as
+// implausible.
+func (a Album) Year() int {
+	if a.BadYear {
+		return 0
+	}
+	return a.ProductionYear
+}
+
+// UserData is the requesting user's play state for a Jellyfin item.
+type UserData struct {
+	PlayCount  int  `json:"PlayCount"`
+	IsFavorite bool `json:"IsFavorite"`
+}
+
+// PlayCount returns how often the user played a, or 0 if unknown.
+func (a Album) PlayCount() int {
+	if a.UserData == nil {
+		return 0
+	}
+	return a.UserData.PlayCount
+}
+
+// IsFavorite reports whether the user marked a as a favorite.
+func (a Album) IsFavorite() bool {
+	return a.UserData != nil && a.UserData.IsFavorite
+}
+
+// Person is a credited person on a Jellyfin item, e.g. a composer.
+type Person struct {
+	Name string `json:"Name"`
+	Type string `json:"Type"` // e.g. "Composer", "Conductor"
+}
+
+// Composers returns the names of the people credited as composers.
+func (a Album) Composers() []string {
+	var out []string
+	for _, p := range a.People {
+		if p.Type == "Composer" {
+			out = append(out, p.Name)
+		}
+	}
+	return out
+}
+
+// artistCandidates returns the non-empty artist names a is known under,
+// drawn from the given fields (see ArtistFieldAlbumArtist and friends).
+func (a Album) artistCandidates(fields []string) []string {
+	var out []string
+	add := func(names ...string) {
+		for _, n := range names {
+			if n = strings.TrimSpace(n); n != "" && !slices.Contains(out, n) {
+				out = append(out, n)
+			}
+		}
+	}
+	for _, f := range fields {
+		switch f {
+		case ArtistFieldAlbumArtist:
+			add(a.AlbumArtist)
+			add(a.AltArtists...)
+			for _, n := range a.AlbumArtists {
+				add(n.Name)
+			}
+		case ArtistFieldArtists:
+			add(a.Artists...)
+		case ArtistFieldComposers:
+			add(a.Composers()...)
+		}
+	}
+	return out
+}
+
+// titles returns the titles a is matched under: its Name, then any
+// AltTitles.
+func (a Album) titles() []string {
+	return append([]string{a.Name}, a.AltTitles...)
+}
+
+// fillAlbumArtist sets a blank AlbumArtist to the AlbumArtists' names
+// joined with " & ", as RYM credits collaborations.
+func (a *Album) fillAlbumArtist() {
+	if strings.TrimSpace(a.AlbumArtist) != "" {
+		return
+	}
+	var names []string
+	for _, n := range a.AlbumArtists {
+		if n := strings.TrimSpace(n.Name); n != "" {
+			names = append(names, n)
+		}
+	}
+	a.AlbumArtist = strings.Join(names, " & ")
 }
 
 type NameID struct {
@@ -47,25 +200,186 @@ type itemsResponse struct {
 
 // Client wraps HTTP behavior and base params.
 type Client struct {
-	BaseURL   string       // e.g. http://localhost:8096
-	Token     string       // Jellyfin API token (user session token or API key)
-	HTTP      *http.Client // optional; if nil a sane default is used
-	UserAgent string       // optional; a sensible default is used if empty
+	BaseURL   string // e.g. http://localhost:8096
+	Token     string // Jellyfin API token (user session token or API key)
+	UserAgent string // optional; a sensible default is used if empty
+
+	// HTTP sends every request. NewClient sets one with timeouts
+	// suited to a LAN server; replace it, or just its Transport, to go
+	// through a proxy or to talk to an httptest.Server. If nil,
+	// http.DefaultClient is used.
+	HTTP *http.Client
+
+	// TokenInQuery also sends Token as the api_key query parameter, for
+	// proxies that strip the X-MediaBrowser-Token header.
+	TokenInQuery bool
+
+	// Retries is how many times a request failing with a connection
+	// error or a 429, 500, 502, 503 or 504 is retried. The delay before
+	// retry n is about RetryDelay * 2^n, or what a 429's Retry-After
+	// asks for.
+	Retries    int
+	RetryDelay time.Duration
+
+	// Concurrency is how many album pages GetAllAlbums fetches at
+	// once. NewClient sets 1, fetching them one after the other.
+	Concurrency int
+
+	// ParentIDs, when set, limits GetAllAlbums to the libraries with
+	// these IDs; see ResolveLibraries.
+	ParentIDs []string
+
+	// UserID, when set, scopes item queries to that user, so play counts
+	// and favorites are theirs. See GetCurrentUserID.
+	UserID string
+}
+
+// errUnauthorized is returned by GetCurrentUserID for a token that
+// belongs to no user, as API keys don't.
+var errUnauthorized = errors.New("token has no user (an API key?)")
+
+// errBadToken matches, with errors.Is, the error of any Client request
+// Jellyfin answered with a 401: the token is wrong or revoked.
+var errBadToken = errors.New("jellyfin rejected the token")
+
+// statusError is a Client request Jellyfin answered with an unexpected
+// status. Body is the start of the response, which for a 4xx often says
+// what was wrong with the request.
+type statusError struct {
+	Status int
+	URL    string // with the token redacted
+	Body   string
+}
+
+func (e *statusError) Error() string {
+	msg := fmt.Sprintf("bad status %d from %s", e.Status, e.URL)
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+func (e *statusError) Is(target error) bool {
+	return target == errBadToken && e.Status == http.StatusUnauthorized
+}
+
+// maxErrorBody is how much of a response body a statusError keeps.
+const maxErrorBody = 512
+
+// badStatus returns the statusError for resp, reading the start of its
+// body.
+func badStatus(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	e := &statusError{Status: resp.StatusCode, Body: strings.TrimSpace(strings.ToValidUTF8(string(b), ""))}
+	if resp.Request != nil {
+		e.URL = redactToken(resp.Request.URL.String())
+	}
+	return e
 }
 
 var (
-	albumList []Album
+	libraryMu       sync.RWMutex
+	albumList       []Album   // sorted by artist, then title; replaced, never modified
+	libraryLoadedAt time.Time // when albumList was fetched
 )
 
+// currentLibrary returns the Jellyfin library and when it was fetched,
+// reloading it first if libraryLoader finds it stale.
+func currentLibrary() ([]Album, time.Time) {
+	libraryLoader.refreshIfStale()
+	libraryMu.RLock()
+	defer libraryMu.RUnlock()
+	return albumList, libraryLoadedAt
+}
+
+// setLibrary replaces the Jellyfin library with albums, sorted by
+// artist and then title. The load time only moves when the library
+// changed, so cached diffs of an unchanged one stay valid.
+func setLibrary(albums []Album) {
+	albums = slices.Clone(albums)
+	sortAlbums(albums, "artist")
+	libraryMu.Lock()
+	defer libraryMu.Unlock()
+	if libraryLoadedAt.IsZero() || !reflect.DeepEqual(albums, albumList) {
+		albumList, libraryLoadedAt = albums, time.Now()
+	}
+}
+
+// sortAlbums orders albums by artist, then title, ignoring case; by
+// title, then artist; or, by "year", oldest first, unknown years last,
+// then by artist and title. Ties keep their order.
+func sortAlbums(albums []Album, by string) {
+	artistTitle := func(a, b Album) int {
+		if c := strings.Compare(strings.ToLower(a.AlbumArtist), strings.ToLower(b.AlbumArtist)); c != 0 {
+			return c
+		}
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	}
+	switch by {
+	case "artist":
+		slices.SortStableFunc(albums, artistTitle)
+	case "title":
+		slices.SortStableFunc(albums, func(a, b Album) int {
+			if c := strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)); c != 0 {
+				return c
+			}
+			return strings.Compare(strings.ToLower(a.AlbumArtist), strings.ToLower(b.AlbumArtist))
+		})
+	case "year":
+		slices.SortStableFunc(albums, func(a, b Album) int {
+			if ya, yb := a.Year(), b.Year(); ya != yb {
+				switch {
+				case ya == 0:
+					return 1
+				case yb == 0:
+					return -1
+				}
+				return ya - yb
+			}
+			return artistTitle(a, b)
+		})
+	}
+}
+
+// artistGroup is a run of albums by one artist, for the missing view.
+// First is the position of its first album in the whole list.
+type artistGroup struct {
+	Artist string
+	First  int
+	Albums []Album
+}
+
+// groupByArtist splits albums, sorted by artist, into runs by the same
+// artist, ignoring case.
+func groupByArtist(albums []Album) []artistGroup {
+	var groups []artistGroup
+	for i, a := range albums {
+		if n := len(groups); n > 0 && strings.EqualFold(groups[n-1].Artist, a.AlbumArtist) {
+			groups[n-1].Albums = append(groups[n-1].Albums, a)
+			continue
+		}
+		groups = append(groups, artistGroup{Artist: a.AlbumArtist, First: i, Albums: []Album{a}})
+	}
+	return groups
+}
+
 const file string = "rymcheck.db"
 
 var pageTpl = template.Must(template.New("page").Funcs(template.FuncMap{
 	"add": func(a, b int) int { return a + b },
+	"truncate": func(s string, n int) string {
+		if r := []rune(s); len(r) > n {
+			return strings.TrimSpace(string(r[:n])) + "…"
+		}
+		return s
+	},
 }).ParseFiles("index.html"))
 
+// NewClient returns a client for the Jellyfin server at baseURL. A
+// trailing slash on baseURL is dropped.
 func NewClient(baseURL, token string) *Client {
 	return &Client{
-		BaseURL: baseURL,
+		BaseURL: strings.TrimRight(baseURL, "/"),
 		Token:   token,
 		HTTP: &http.Client{
 			Timeout: 15 * time.Second,
@@ -82,224 +396,1985 @@ func NewClient(baseURL, token string) *Client {
 				ExpectContinueTimeout: 1 * time.Second,
 			},
 		},
-		UserAgent: "Jellyfin-Go/1.0 (+https://example.com)",
+		UserAgent:   "Jellyfin-Go/1.0 (+https://example.com)",
+		Retries:     3,
+		RetryDelay:  500 * time.Millisecond,
+		Concurrency: 1,
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTP == nil {
+		return http.DefaultClient
+	}
+	return c.HTTP
+}
+
+// do sends req with c's token, retrying transient failures. Errors
+// never include the token, even when it is in the URL.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-MediaBrowser-Token", c.Token)
+	if c.TokenInQuery {
+		q := req.URL.Query()
+		q.Set("api_key", c.Token)
+		req.URL.RawQuery = q.Encode()
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := c.httpClient().Do(req)
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			uerr.URL = redactToken(uerr.URL)
+		}
+		if attempt >= c.Retries || !retryable(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+		wait := retryAfter(resp)
+		if wait == 0 {
+			d := c.RetryDelay << attempt
+			wait = d/2 + rand.N(d+1) // jitter, so clients don't retry in step
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryable reports whether a request that got resp and err may
+// succeed if sent again.
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns how long a 429 response asks to be left alone, or
+// 0 if it doesn't say.
+func retryAfter(resp *http.Response) time.Duration {
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		return 0
+	}
+	v := resp.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// redactToken replaces the api_key in rawURL, so it can be logged.
+func redactToken(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "(unparsable URL)"
 	}
+	q := u.Query()
+	if !q.Has("api_key") {
+		return rawURL
+	}
+	q.Set("api_key", "REDACTED")
+	u.RawQuery = q.Encode()
+	return u.String()
 }
 
+// albumPageSize is how many albums GetAllAlbums asks for at a time.
+const albumPageSize = 200
+
+// GetAllAlbums returns every music album in the library, sorted by name,
+// or in each of c.ParentIDs one after the other.
 func (c *Client) GetAllAlbums(ctx context.Context) ([]Album, error) {
-	const pageSize = 200
-	startIndex := 0
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse base url: %w", err)
+	}
+
+	path := "/Items"
+	if c.UserID != "" {
+		path = "/Users/" + url.PathEscape(c.UserID) + "/Items"
+	}
+	items := base.ResolveReference(&url.URL{Path: path})
+	if len(c.ParentIDs) == 0 {
+		return c.getAlbums(ctx, items)
+	}
+
 	var all []Album
+	seen := make(map[string]bool)
+	for _, id := range c.ParentIDs {
+		u := *items
+		u.RawQuery = url.Values{"ParentId": {id}}.Encode()
+		albums, err := c.getAlbums(ctx, &u)
+		if err != nil {
+			return nil, fmt.Errorf("library %s: %w", id, err)
+		}
+		for _, a := range albums {
+			// An album can sit in two libraries sharing a folder.
+			if a.ID == "" || !seen[a.ID] {
+				seen[a.ID] = true
+				all = append(all, a)
+			}
+		}
+	}
+	return all, nil
+}
+
+// getAlbums returns the albums at items, sorted by name. Once the first
+// page tells how many there are, the rest are fetched up to
+// c.Concurrency at a time.
+func (c *Client) getAlbums(ctx context.Context, items *url.URL) ([]Album, error) {
+	first, err := c.getAlbumPage(ctx, items, 0)
+	if err != nil {
+		return nil, err
+	}
+	all := first.Items
+	if c.Concurrency <= 1 {
+		for len(all) < first.TotalRecordCount {
+			ir, err := c.getAlbumPage(ctx, items, len(all))
+			if err != nil {
+				return nil, err
+			}
+			if len(ir.Items) == 0 {
+				break
+			}
+			all = append(all, ir.Items...)
+		}
+		return all, nil
+	}
+
+	// The pages after the first, each filled in by one worker so their
+	// order is that of their start indices. They are as long as the
+	// first, in case the server caps the page size below ours.
+	step := len(first.Items)
+	if step == 0 {
+		return all, nil
+	}
+	pages := make([][]Album, (first.TotalRecordCount-1)/step)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make([]error, len(pages))
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range min(c.Concurrency, len(pages)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(pages) {
+					return
+				}
+				ir, err := c.getAlbumPage(ctx, items, (i+1)*step)
+				if err != nil {
+					errs[i] = err
+					cancel() // the result is useless without every page
+					return
+				}
+				pages[i] = ir.Items
+			}
+		}()
+	}
+	wg.Wait()
+	if err := pageErrors(errs); err != nil {
+		return nil, err
+	}
+	for _, p := range pages {
+		all = append(all, p...)
+	}
+	return all, nil
+}
+
+// pageErrors joins the errors of the failed pages, leaving out those
+// that only failed because an earlier failure cancelled them.
+func pageErrors(errs []error) error {
+	var failed, cancelled []error
+	for _, err := range errs {
+		switch {
+		case err == nil:
+		case errors.Is(err, context.Canceled):
+			cancelled = append(cancelled, err)
+		default:
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == 0 {
+		failed = cancelled
+	}
+	return errors.Join(failed...)
+}
+
+// getAlbumPage fetches the albums at items starting at start.
+func (c *Client) getAlbumPage(ctx context.Context, items *url.URL, start int) (itemsResponse, error) {
+	var ir itemsResponse
+	u := *items
+	q := u.Query()
+	q.Set("IncludeItemTypes", "MusicAlbum")
+	q.Set("Recursive", "true")
+	q.Set("SortBy", "SortName")
+	q.Set("SortOrder", "Ascending")
+	q.Set("StartIndex", fmt.Sprintf("%d", start))
+	q.Set("Limit", fmt.Sprintf("%d", albumPageSize))
+	q.Set("Fields", "PrimaryImageTag,AlbumArtist,AlbumArtists,Artists,People,ProductionYear,Overview,ProviderIds,Genres")
+	q.Set("EnableUserData", "true")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return ir, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return ir, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ir, fmt.Errorf("page at %d: %w", start, badStatus(resp))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ir); err != nil {
+		return ir, err
+	}
+	for i := range ir.Items {
+		ir.Items[i].fillAlbumArtist()
+	}
+	return ir, nil
+}
+
+// VirtualFolder is a Jellyfin library, from /Library/VirtualFolders.
+type VirtualFolder struct {
+	Name           string `json:"Name"`
+	ItemID         string `json:"ItemId"`
+	CollectionType string `json:"CollectionType"` // "music", "books", …
+}
+
+// GetLibraries returns the server's libraries.
+func (c *Client) GetLibraries(ctx context.Context) ([]VirtualFolder, error) {
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse base url: %w", err)
+	}
+	u := base.ResolveReference(&url.URL{Path: "/Library/VirtualFolders"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, badStatus(resp)
+	}
+	var folders []VirtualFolder
+	err = json.NewDecoder(resp.Body).Decode(&folders)
+	return folders, err
+}
+
+// resolveLibraries maps each of wanted, a library's name (any case) or
+// ID, to the library's ID, failing on the first that names no library.
+// The IDs come back in the order asked for, without repeats.
+func resolveLibraries(folders []VirtualFolder, wanted []string) ([]string, error) {
+	var ids []string
+	for _, w := range wanted {
+		id := ""
+		for _, f := range folders {
+			if f.ItemID == w || strings.EqualFold(f.Name, w) {
+				id = f.ItemID
+				break
+			}
+		}
+		if id == "" {
+			names := make([]string, len(folders))
+			for i, f := range folders {
+				names[i] = f.Name
+			}
+			return nil, fmt.Errorf("no library named %q (have %s)", w, strings.Join(names, ", "))
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// GetCurrentUserID returns the ID of the user c's token belongs to,
+// from /Users/Me. It returns errUnauthorized if there is none.
+func (c *Client) GetCurrentUserID(ctx context.Context) (string, error) {
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return "", fmt.Errorf("parse base url: %w", err)
+	}
+	u := base.ResolveReference(&url.URL{Path: "/Users/Me"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return "", errUnauthorized
+	default:
+		return "", badStatus(resp)
+	}
+	var user struct {
+		ID string `json:"Id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return "", err
+	}
+	if user.ID == "" {
+		return "", errors.New("no user ID in /Users/Me response")
+	}
+	return user.ID, nil
+}
+
+// ImageURL returns the URL of the primary image with tag of the item
+// albumID, scaled to at most maxWidth pixels wide if maxWidth > 0. With
+// no ID or tag there is no image and it returns "".
+func (c *Client) ImageURL(albumID, tag string, maxWidth int) string {
+	if albumID == "" || tag == "" {
+		return ""
+	}
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return ""
+	}
+	u := base.ResolveReference(&url.URL{Path: "/Items/" + albumID + "/Images/Primary"})
+	q := url.Values{"tag": {tag}}
+	if maxWidth > 0 {
+		q.Set("maxWidth", strconv.Itoa(maxWidth))
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// thumbnailWidth is the width, in pixels, covers are shown at.
+const thumbnailWidth = 48
+
+// coverURLs maps the ID of each of albums with cover art to the URL of
+// its thumbnail on c's server. With no server there are none.
+func coverURLs(c *Client, albums []Album) map[string]string {
+	covers := make(map[string]string)
+	if c == nil {
+		return covers
+	}
+	for _, a := range albums {
+		// Twice the width shown, for high-density screens.
+		if u := c.ImageURL(a.ID, a.PrimaryImageTag, 2*thumbnailWidth); u != "" {
+			covers[a.ID] = u
+		}
+	}
+	return covers
+}
+
+// ServerInfo identifies a Jellyfin server, from /System/Info/Public.
+type ServerInfo struct {
+	ServerName string `json:"ServerName"`
+	Version    string `json:"Version"`
+	ID         string `json:"Id"`
+}
+
+func (c *Client) GetServerInfo(ctx context.Context) (ServerInfo, error) {
+	var info ServerInfo
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return info, fmt.Errorf("parse base url: %w", err)
+	}
+	u := base.ResolveReference(&url.URL{Path: "/System/Info/Public"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return info, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return info, badStatus(resp)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	return info, err
+}
+
+// serverInfoCache keeps the ServerInfo of the library's server, which
+// rarely changes, refetching it once it is older than TTL.
+type serverInfoCache struct {
+	Client *Client
+	TTL    time.Duration
+
+	mu      sync.Mutex
+	info    ServerInfo
+	fetched time.Time
+}
+
+var serverInfo = &serverInfoCache{TTL: time.Hour}
+
+// Get returns the cached ServerInfo, fetching it first if it is stale.
+// If the fetch fails, the last known info is returned with the error.
+func (s *serverInfoCache) Get(ctx context.Context) (ServerInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Client == nil {
+		return s.info, errNoServer
+	}
+	if !s.fetched.IsZero() && time.Since(s.fetched) < s.TTL {
+		return s.info, nil
+	}
+	info, err := s.Client.GetServerInfo(ctx)
+	if err != nil {
+		return s.info, err
+	}
+	s.info, s.fetched = info, time.Now()
+	return info, nil
+}
+
+// Cached returns the last fetched ServerInfo without fetching, for pages
+// that shouldn't wait on Jellyfin.
+func (s *serverInfoCache) Cached() ServerInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.info
+}
+
+// NormalizeConfig selects the optional rules normalize applies on top of
+// lowercasing and stripping accents and punctuation.
+type NormalizeConfig struct {
+	// Conjunctions writes every spelling of "and" the same way; see
+	// canonicalConjunctions.
+	Conjunctions bool `json:"conjunctions"`
+
+	// Abbreviations spells out series markers before a number, so
+	// "Pt. II", "Part 2" and "part two" all become "part 2". Likewise
+	// "Vol." becomes "volume" and "No." becomes "number".
+	Abbreviations bool `json:"abbreviations"`
+
+	// Dots treats a period as a word break and then joins runs of single
+	// letters, so "Dr. Dre", "Dr Dre" and "Dr.Dre" agree, as do "R.E.M."
+	// and "REM".
+	Dots bool `json:"dots"`
+
+	// NoiseWords lists words and phrases, like "OST" or "Original
+	// Motion Picture Soundtrack", dropped once everything else is
+	// normalized. A string made only of them is left whole.
+	NoiseWords []string `json:"noise_words"`
+
+	// Locale, a BCP 47 tag like "de" or "tr", picks the language rules
+	// for lowercasing (Turkish dotless i, say), letters spelled out
+	// rather than stripped of their accent (German "ü" as "ue", "ß" as
+	// "ss") and the leading article dropped ("Die", "The", "Le"), also
+	// when a sort name puts it last ("Beatles, The"). Empty applies none
+	// of these.
+	Locale string `json:"locale"`
+}
+
+// defaultTitleNoise is the NoiseWords of the default title config:
+// soundtrack and format terms one source adds and the other omits.
+var defaultTitleNoise = []string{
+	"original motion picture soundtrack",
+	"music from the motion picture",
+	"motion picture soundtrack",
+	"original soundtrack",
+	"original score",
+	"soundtrack",
+	"ost",
+	"lp",
+	"ep",
+}
+
+func normalize(s string, cfg NormalizeConfig) string {
+	// decompose accents, then strip them
+	rules := rulesFor(cfg.Locale)
+	lower := strings.ToLower
+	if rules != nil {
+		lower = rules.lower
+	}
+	t := norm.NFD.String(lower(s))
+	if rules != nil {
+		t = rules.frontArticle(t)
+	}
+	if cfg.Conjunctions {
+		t = canonicalConjunctions(t)
+	}
+	if cfg.Dots {
+		t = strings.ReplaceAll(t, ".", " ")
+	}
+	var b strings.Builder
+	for _, r := range t {
+		if unicode.Is(unicode.Mn, r) {
+			continue // skip diacritic
+		}
+		if unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsSpace(r) {
+			b.WriteRune(r)
+		}
+	}
+	words := strings.Fields(b.String())
+	if cfg.Dots {
+		words = joinInitials(words)
+	}
+	if rules != nil {
+		words = rules.dropArticle(words)
+	}
+	if cfg.Abbreviations {
+		words = expandSeriesMarkers(words)
+	}
+	if len(cfg.NoiseWords) > 0 {
+		words = dropNoise(words, cfg.NoiseWords)
+	}
+	return strings.Join(words, " ") // collapse spaces
+}
+
+// conjunctions are the spellings of "and" canonicalConjunctions knows,
+// once lowercased and with plain apostrophes.
+var conjunctions = map[string]bool{"&": true, "+": true, "and": true, "n": true, "n'": true, "'n": true, "'n'": true}
+
+var curlyApostrophes = strings.NewReplacer("’", "'", "‘", "'")
+
+// canonicalConjunctions rewrites each spelling of "and" in the lowercase
+// s as the word "and", so that "Simon & Garfunkel", "Guns N' Roses",
+// "Rock 'n' Roll" and "Florence + the Machine" agree with their spelled
+// out forms. An ampersand counts anywhere, the others only as words of
+// their own: "C++" keeps its pluses.
+func canonicalConjunctions(s string) string {
+	s = curlyApostrophes.Replace(strings.ReplaceAll(s, "&", " & "))
+	words := strings.Fields(s)
+	for i, w := range words {
+		if conjunctions[w] {
+			words[i] = "and"
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// dropNoise removes each occurrence of a noise phrase from words,
+// preferring the longest phrase where several start at the same word.
+// If nothing would be left, words is returned as is.
+func dropNoise(words, noise []string) []string {
+	phrases := make([][]string, 0, len(noise))
+	for _, p := range noise {
+		if f := strings.Fields(normalize(p, NormalizeConfig{})); len(f) > 0 {
+			phrases = append(phrases, f)
+		}
+	}
+	sort.SliceStable(phrases, func(i, j int) bool { return len(phrases[i]) > len(phrases[j]) })
+
+	var out []string
+next:
+	for i := 0; i < len(words); i++ {
+		for _, p := range phrases {
+			if i+len(p) <= len(words) && slices.Equal(words[i:i+len(p)], p) {
+				i += len(p) - 1
+				continue next
+			}
+		}
+		out = append(out, words[i])
+	}
+	if len(out) == 0 {
+		return words
+	}
+	return out
+}
+
+// joinInitials joins each run of two or more single-letter words into
+// one word: "r e m" becomes "rem".
+func joinInitials(words []string) []string {
+	out := words[:0]
+	run := 0 // length of the run of single letters ending at out's tail
+	for _, w := range words {
+		if utf8.RuneCountInString(w) == 1 && unicode.IsLetter([]rune(w)[0]) {
+			if run > 0 {
+				out[len(out)-1] += w
+				run++
+				continue
+			}
+			run = 1
+		} else {
+			run = 0
+		}
+		out = append(out, w)
+	}
+	return out
+}
+
+// seriesMarkers maps the abbreviated and full forms of words that
+// introduce a number in a series title to their full form.
+var seriesMarkers = map[string]string{
+	"pt": "part", "part": "part",
+	"vol": "volume", "vols": "volume", "volume": "volume",
+	"no": "number", "nr": "number", "number": "number",
+}
+
+// seriesNumbers maps spelled-out and roman numerals to digits.
+var seriesNumbers = map[string]string{
+	"one": "1", "two": "2", "three": "3", "four": "4", "five": "5",
+	"six": "6", "seven": "7", "eight": "8", "nine": "9", "ten": "10",
+	"i": "1", "ii": "2", "iii": "3", "iv": "4", "v": "5",
+	"vi": "6", "vii": "7", "viii": "8", "ix": "9", "x": "10",
+	"xi": "11", "xii": "12", "xiii": "13", "xiv": "14", "xv": "15",
+	"xvi": "16", "xvii": "17", "xviii": "18", "xix": "19", "xx": "20",
+}
+
+// expandSeriesMarkers rewrites a series marker followed by a number into
+// "<marker> <digits>". A marker with no number after it, like the "no"
+// in "No Surprises", is left alone.
+func expandSeriesMarkers(words []string) []string {
+	for i := 0; i+1 < len(words); i++ {
+		full, ok := seriesMarkers[words[i]]
+		if !ok {
+			continue
+		}
+		next := words[i+1]
+		if n, ok := seriesNumbers[next]; ok {
+			next = n
+		} else if _, err := strconv.Atoi(next); err != nil {
+			continue
+		}
+		words[i], words[i+1] = full, next
+		i++
+	}
+	return words
+}
+
+// similarity returns [0..1] based on Levenshtein distance
+func similarity(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	d := levenshtein.DistanceForStrings([]rune(a), []rune(b), levenshtein.DefaultOptions)
+	maxLen := len([]rune(a))
+	if len([]rune(b)) > maxLen {
+		maxLen = len([]rune(b))
+	}
+	if maxLen == 0 {
+		return 1
+	}
+	return 1 - float64(d)/float64(maxLen)
+}
+
+// similarityAbove returns similarity(a, b), or 0 once it is certain the
+// result can't exceed threshold. As similarity is 1-d/maxLen, that is
+// when the distance d reaches (1-threshold)*maxLen, which lets the
+// distance computation stop early on clearly different strings. A
+// positive maxDist also rejects pairs further apart than that.
+func similarityAbove(a, b string, threshold float64, maxDist int) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	ra, rb := []rune(a), []rune(b)
+	maxLen := max(len(ra), len(rb))
+	bound := int((1 - threshold) * float64(maxLen))
+	if maxDist > 0 && maxDist < bound {
+		bound = maxDist
+	}
+	d, ok := boundedDistance(ra, rb, bound)
+	if !ok {
+		return 0
+	}
+	return 1 - float64(d)/float64(maxLen)
+}
+
+// boundedDistance computes the same distance as levenshtein.DefaultOptions
+// (insert and delete cost 1, substitute 2) but gives up, returning false,
+// as soon as it is known to exceed bound.
+func boundedDistance(a, b []rune, bound int) (int, bool) {
+	if bound < 0 || abs(len(a)-len(b)) > bound {
+		return 0, false
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := i
+		for j := 1; j <= len(b); j++ {
+			c := prev[j-1]
+			if a[i-1] != b[j-1] {
+				c += 2
+			}
+			c = min(c, prev[j]+1, cur[j-1]+1)
+			cur[j] = c
+			rowMin = min(rowMin, c)
+		}
+		// Row minimums never decrease, so nothing below can recover.
+		if rowMin > bound {
+			return 0, false
+		}
+		prev, cur = cur, prev
+	}
+	d := prev[len(b)]
+	return d, d <= bound
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// viewOptions holds the per-request choices for what the results show.
+type viewOptions struct {
+	View       string // "missing" (default), "reverse", "decades", "matches", "title_matches", "coverage" or "report"; "duplicates" on /dedupe
+	Confidence string // matches view only; empty means all
+	HideYear   bool   // drop the year column; the year moves to a tooltip
+	Overview   bool   // show each missing album's Jellyfin overview
+
+	// Cover limits the library to albums "with" or "without" cover art
+	// (a PrimaryImageTag); empty keeps all.
+	Cover string
+
+	// Genre limits the comparison to RYM albums with a genre or
+	// descriptor containing it, after normalization.
+	Genre string
+
+	// Missing view only.
+	Format        string   // "" for JSON, "csv" or "opml"; API only. See also "ids".
+	Fields        []string // albumFields names for JSON and CSV; nil for all
+	Sort          string   // "" keeps library order, by artist; "artist", "title", "year", "score", "plays" or "favorites"
+	FavoritesOnly bool
+	MinPlays      int
+
+	// HTML pages only: which page of the missing or reverse list to
+	// show, from 1, and how many albums a page holds. Query is the
+	// request's other parameters, the CSV aside, for links to the
+	// other pages.
+	Page     int
+	PageSize int
+	Query    template.URL
+
+	// diffed, when a handler has already diffed the whole library
+	// against the list, holds the albums that diff found missing, so
+	// the missing views don't match again.
+	diffed *[]missingAlbum
+}
+
+// defaultPageSize is how many albums a results page shows if the
+// request doesn't say.
+const defaultPageSize = 100
+
+// pageBounds returns the slice of n albums on page o.Page, clamped to
+// the pages there are, and which page that is out of how many.
+func (o viewOptions) pageBounds(n int) (lo, hi, page, pages int) {
+	size := cmp.Or(o.PageSize, defaultPageSize)
+	pages = max((n+size-1)/size, 1)
+	page = min(max(o.Page, 1), pages)
+	lo = (page - 1) * size
+	return lo, min(lo+size, n), page, pages
+}
+
+// filterRYM applies the genre filter to a parsed RYM list. If no album
+// in the list has genre data, the export lacked those columns and the
+// list is returned as is.
+func (o viewOptions) filterRYM(rym []Album) []Album {
+	if o.Genre == "" {
+		return rym
+	}
+	want := normalize(o.Genre, NormalizeConfig{})
+	var out []Album
+	hasGenres := false
+	for _, a := range rym {
+		if len(a.Genres) > 0 || len(a.Descriptors) > 0 {
+			hasGenres = true
+		}
+		for _, g := range slices.Concat(a.Genres, a.Descriptors) {
+			if strings.Contains(normalize(g, NormalizeConfig{}), want) {
+				out = append(out, a)
+				break
+			}
+		}
+	}
+	if !hasGenres {
+		return rym
+	}
+	return out
+}
+
+// filterLibrary applies the cover art filter to the library.
+func (o viewOptions) filterLibrary(library []Album) []Album {
+	if o.Cover == "" {
+		return library
+	}
+	var out []Album
+	for _, a := range library {
+		if (a.PrimaryImageTag != "") == (o.Cover == "with") {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// keep reports whether a missing album passes the favorite and play
+// count filters.
+func (o viewOptions) keep(a Album) bool {
+	return (!o.FavoritesOnly || a.IsFavorite()) && a.PlayCount() >= o.MinPlays
+}
+
+// sortMissing orders albums by o.Sort, other than "score". Ties keep
+// their library order.
+func (o viewOptions) sortMissing(albums []Album) {
+	switch o.Sort {
+	case "artist", "title", "year":
+		sortAlbums(albums, o.Sort)
+	case "plays":
+		sort.SliceStable(albums, func(i, j int) bool { return albums[i].PlayCount() > albums[j].PlayCount() })
+	case "favorites":
+		sort.SliceStable(albums, func(i, j int) bool { return albums[i].IsFavorite() && !albums[j].IsFavorite() })
+	}
+}
+
+// missingAlbums returns the library albums missing from rym that pass
+// the filters, in the chosen order, less those needing review. library
+// itself is left whole, so later comparisons still see all of it.
+func (o viewOptions) missingAlbums(library, rym []Album, cfg MatchConfig) []Album {
+	missing, _ := o.missingAndReview(library, rym, cfg)
+	return missing
+}
+
+// missingAndReview is missingAlbums, also returning the closest pairs of
+// the albums it leaves out for needing review, closest first.
+func (o viewOptions) missingAndReview(library, rym []Album, cfg MatchConfig) ([]Album, []Match) {
+	var missing []missingAlbum
+	var review []Match
+	_ = o.eachMissing(library, rym, cfg, func(m missingAlbum) error {
+		switch {
+		case !o.keep(m.Album):
+		case m.needsReview(cfg):
+			review = append(review, m.nearest)
+		default:
+			missing = append(missing, m)
+		}
+		return nil
+	})
+	slices.SortStableFunc(review, func(a, b Match) int { return cmp.Compare(b.Score, a.Score) })
+	if o.Sort == "score" {
+		sortByNearestMatch(missing)
+	}
+	var out []Album
+	for _, m := range missing {
+		out = append(out, m.Album)
+	}
+	if o.Sort != "score" {
+		o.sortMissing(out)
+	}
+	return out, review
+}
+
+// eachMissing is forEachMissing, taking the albums from o.diffed when
+// neither Cover nor Genre filters what it was run on.
+func (o viewOptions) eachMissing(library, rym []Album, cfg MatchConfig, fn func(missingAlbum) error) error {
+	if o.diffed == nil || o.Cover != "" || o.Genre != "" {
+		return forEachMissing(library, rym, cfg, fn)
+	}
+	for _, m := range *o.diffed {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listedAlbums returns the albums the missing, decades or reverse view
+// lists: those of library missing from rym or, in the reverse view,
+// those of rym not in library.
+func (o viewOptions) listedAlbums(library, rym []Album, cfg MatchConfig) []Album {
+	if o.View == "reverse" {
+		return notInLibrary(library, rym, cfg)
+	}
+	return o.missingAlbums(library, rym, cfg)
+}
+
+// sortByNearestMatch orders missing albums by the score of the RYM album
+// the diff found closest to each, highest first, so the near misses,
+// which a lower threshold or an alias would match, come first.
+func sortByNearestMatch(missing []missingAlbum) {
+	slices.SortStableFunc(missing, func(a, b missingAlbum) int { return cmp.Compare(b.nearest.Score, a.nearest.Score) })
+}
+
+// decadeGroup is one row of the decades view: the missing albums from
+// one decade.
+type decadeGroup struct {
+	Decade string  `json:"decade"` // "1970s", or "Unknown" without a year
+	Count  int     `json:"count"`
+	Albums []Album `json:"albums"`
+}
+
+// groupByDecade buckets albums by the decade of their year, oldest
+// first, with the albums lacking a year last.
+func groupByDecade(albums []Album) []decadeGroup {
+	byDecade := make(map[int][]Album)
+	for _, a := range albums {
+		d := -1
+		if y := a.Year(); y > 0 {
+			d = y / 10 * 10
+		}
+		byDecade[d] = append(byDecade[d], a)
+	}
+	decades := slices.Sorted(maps.Keys(byDecade))
+	if len(decades) > 0 && decades[0] == -1 {
+		decades = append(decades[1:], -1)
+	}
+	out := make([]decadeGroup, 0, len(decades))
+	for _, d := range decades {
+		name := "Unknown"
+		if d >= 0 {
+			name = fmt.Sprintf("%ds", d)
+		}
+		out = append(out, decadeGroup{Decade: name, Count: len(byDecade[d]), Albums: byDecade[d]})
+	}
+	return out
+}
+
+// parseViewOptions reads the view options from the query string or form.
+func parseViewOptions(r *http.Request) (viewOptions, error) {
+	opts := viewOptions{View: r.FormValue("view")}
+	switch opts.View {
+	case "":
+		opts.View = "missing"
+	case "missing", "review", "reverse", "decades", "matches", "title_matches", "coverage", "report":
+	default:
+		return opts, fmt.Errorf("unknown view %q", opts.View)
+	}
+	c, err := parseConfidence(r.FormValue("confidence"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Confidence = c
+	opts.HideYear, _ = strconv.ParseBool(r.FormValue("hide_year"))
+	opts.Overview, _ = strconv.ParseBool(r.FormValue("overview"))
+	opts.Genre = strings.TrimSpace(r.FormValue("genre"))
+	switch opts.Cover = r.FormValue("cover"); opts.Cover {
+	case "", "with", "without":
+	default:
+		return opts, fmt.Errorf("unknown cover %q (want with or without)", opts.Cover)
+	}
+
+	switch opts.Sort = r.FormValue("sort"); opts.Sort {
+	case "", "artist", "title", "year", "score", "plays", "favorites":
+	case "coverage":
+		if opts.View != "coverage" {
+			return opts, fmt.Errorf("sort coverage needs the coverage view")
+		}
+	default:
+		return opts, fmt.Errorf("unknown sort %q (want artist, title, year, score, plays, favorites or coverage)", opts.Sort)
+	}
+	switch opts.Format = r.FormValue("format"); opts.Format {
+	case "":
+	case "csv":
+		if !slices.Contains([]string{"missing", "reverse", "coverage", "report"}, opts.View) {
+			return opts, fmt.Errorf("format csv needs the missing, reverse, coverage or report view")
+		}
+	case "opml":
+		if opts.View != "missing" {
+			return opts, fmt.Errorf("format opml needs the missing view")
+		}
+	case "ids", "ids_json":
+		if opts.View != "matches" {
+			return opts, fmt.Errorf("format %s needs the matches view", opts.Format)
+		}
+	default:
+		return opts, fmt.Errorf("unknown format %q (want csv, opml, ids or ids_json)", opts.Format)
+	}
+	if opts.Fields, err = parseFields(r.FormValue("fields")); err != nil {
+		return opts, err
+	}
+	opts.FavoritesOnly, _ = strconv.ParseBool(r.FormValue("favorites"))
+	if v := r.FormValue("min_plays"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("min_plays must be a non-negative integer")
+		}
+		opts.MinPlays = n
+	}
+	return opts, nil
+}
+
+func renderForm(w http.ResponseWriter, albums []Album, errMsg string, warnings []LineError, opts viewOptions, cfg MatchConfig) {
+	albums = opts.filterRYM(albums)
+	all, _ := currentLibrary()
+	library := opts.filterLibrary(all)
+
+	var missing []Album
+	var matches, tentative, review []Match
+	var coverage []artistCoverage
+	var notOwned []Album
+	var duplicates []duplicateGroup
+	switch opts.View {
+	case "matches":
+		for _, m := range findMatches(library, albums, cfg, opts.Confidence) {
+			if m.Tentative {
+				tentative = append(tentative, m)
+			} else {
+				matches = append(matches, m)
+			}
+		}
+	case "title_matches":
+		matches = findTitleMatches(library, albums, cfg)
+	case "coverage":
+		if len(albums) > 0 {
+			coverage = coverageByArtist(library, albums, cfg, opts.Sort == "coverage")
+		}
+	case "reverse":
+		notOwned = opts.listedAlbums(library, albums, cfg)
+	case "duplicates":
+		duplicates = findDuplicates(library, cfg)
+	default:
+		missing, review = opts.missingAndReview(library, albums, cfg)
+	}
+	var decades []decadeGroup
+	if opts.View == "decades" {
+		decades = groupByDecade(missing)
+	}
+	// The page only prints the scores under the missing albums.
+	var jsonOut string
+	if len(missing) > 0 && decades == nil {
+		buf, _ := json.MarshalIndent(scoreRYM(library, albums, cfg), "", "  ")
+		jsonOut = string(buf)
+	}
+	// Only one of missing and notOwned is set, and only it is paged.
+	total := len(missing) + len(notOwned)
+	lo, hi, page, pages := opts.pageBounds(total)
+	pageOf := func(albums []Album) []Album {
+		if albums == nil {
+			return nil
+		}
+		return albums[lo:hi]
+	}
+	// Sorted by artist, the missing albums are grouped by artist too.
+	grouped := opts.Sort == "" || opts.Sort == "artist"
+	groups := []artistGroup{{First: lo, Albums: pageOf(missing)}}
+	if grouped {
+		groups = groupByArtist(pageOf(missing))
+		for i := range groups {
+			groups[i].First += lo
+		}
+	}
+
+	err := pageTpl.ExecuteTemplate(w, "page", map[string]any{
+		"Albums":    missing,
+		"Groups":    groups,
+		"Grouped":   grouped,
+		"Covers":    coverURLs(serverInfo.Client, pageOf(missing)),
+		"ThumbSize": thumbnailWidth,
+		"Bad":       badMetadata(all, cfg),
+		"Server":    serverInfo.Cached(),
+		"Decades":   decades,
+		"Coverage":  coverage,
+		"NotOwned":  pageOf(notOwned),
+		"Total":     total,
+		"Page":      page,
+		"Pages":     pages,
+		"PageStart": lo,
+		"Dupes":     duplicates,
+		"Config":    cfg,
+		"HaveRYM":   len(albums) > 0,
+		"Matches":   matches,
+		"Tentative": tentative,
+		"Review":    review,
+		"View":      opts,
+		"JSON":      jsonOut,
+		"Err":       errMsg,
+		"Skipped":   warnings,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// renderBadRequest renders the form with err, and a 400 status, for a
+// request whose view options or config don't parse.
+func renderBadRequest(w http.ResponseWriter, err error, opts viewOptions) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	renderForm(w, nil, err.Error(), nil, opts, currentConfig())
+}
+
+// formViewOptions is parseViewOptions for the HTML pages, which show the
+// reverse diff unless asked otherwise: what's rated on RYM but not in
+// the library is what most visitors are after.
+func formViewOptions(r *http.Request) (viewOptions, error) {
+	opts, err := parseViewOptions(r)
+	if r.FormValue("view") == "" {
+		opts.View = "reverse"
+	}
+	for _, p := range []struct {
+		name string
+		v    *int
+	}{{"page", &opts.Page}, {"page_size", &opts.PageSize}} {
+		if s := r.FormValue(p.name); s != "" {
+			n, perr := strconv.Atoi(s)
+			if perr != nil || n < 1 {
+				return opts, fmt.Errorf("%s must be a positive integer", p.name)
+			}
+			*p.v = n
+		}
+	}
+	q := url.Values{}
+	for k, v := range r.Form {
+		switch k {
+		case "csvtext", "csvurl", "page":
+		default:
+			q[k] = v
+		}
+	}
+	opts.Query = template.URL(q.Encode())
+	return opts, err
+}
+
+func ServeRymCSVForm(mux *http.ServeMux) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			opts, err := formViewOptions(r)
+			if err != nil {
+				renderBadRequest(w, err, opts)
+				return
+			}
+			renderForm(w, nil, "", nil, opts, currentConfig())
+			return
+		case http.MethodPost:
+			// Several files are parsed one by one in parseCSVUploads.
+			files := uploadedFiles(r)
+			var src io.Reader
+			if len(files) <= 1 {
+				var err error
+				if src, err = readCSVUpload(r); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			opts, err := formViewOptions(r)
+			if err != nil {
+				renderBadRequest(w, err, opts)
+				return
+			}
+			cfg, err := configFromRequest(r)
+			if err != nil {
+				renderBadRequest(w, err, opts)
+				return
+			}
+
+			var albums []Album
+			var skipped []LineError
+			var errMsg string
+			if src == nil {
+				var errs []string
+				albums, skipped, errs = parseCSVUploads(files, csvOptionsFrom(r))
+				errMsg = strings.Join(errs, "; ")
+				if len(errs) == len(files) {
+					renderForm(w, nil, errMsg, skipped, opts, cfg)
+					return
+				}
+			} else if albums, skipped, err = parseListCSV(src, csvOptionsFrom(r)); err != nil {
+				renderForm(w, nil, "Parse error: "+err.Error(), nil, opts, cfg)
+				return
+			}
+			rememberRYM(albums)
+			library, _ := currentLibrary()
+			resolveReleaseGroups(r.Context(), cfg, library, albums)
+			e, missing := summarizeDiff(library, albums, cfg)
+			recordDiff(e, missing)
+			opts.diffed = &missing
+			renderForm(w, albums, errMsg, skipped, opts, cfg)
+			return
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	})
+
+	mux.HandleFunc("/dedupe", handleDedupe)
+	mux.HandleFunc("/export.csv", handleExportCSV)
+	mux.HandleFunc("/ignore", handleIgnoreForm)
+
+	// Re-run the comparison against the last uploaded RYM list, e.g.
+	// with a different threshold, without uploading it again.
+	mux.HandleFunc("/rerun", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		opts, err := formViewOptions(r)
+		if err != nil {
+			renderBadRequest(w, err, opts)
+			return
+		}
+		cfg, err := configFromRequest(r)
+		if err != nil {
+			renderBadRequest(w, err, opts)
+			return
+		}
+		albums, _ := lastRYMList()
+		if albums == nil {
+			renderForm(w, nil, "Nothing to re-run yet; upload a CSV first.", nil, opts, cfg)
+			return
+		}
+		library, _ := currentLibrary()
+		resolveReleaseGroups(r.Context(), cfg, library, albums)
+		renderForm(w, albums, "", nil, opts, cfg)
+	})
+}
+
+// exportCSVFields are the columns /export.csv writes unless asked for
+// others. Library albums have no RYM ID, so only the reverse view, which
+// lists RYM albums, adds one.
+var exportCSVFields = []string{"artist", "title", "year"}
+
+// handleExportCSV downloads the albums a results page lists, all of
+// them, as CSV: by default those of the missing view. It takes the
+// page's parameters and, like /rerun, compares the last uploaded list.
+func handleExportCSV(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	opts, err := formViewOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("view") == "" {
+		opts.View = "missing"
+	}
+	filename := "missing.csv"
+	fields := exportCSVFields
+	switch opts.View {
+	case "missing", "decades":
+	case "reverse":
+		filename = "not-in-library.csv"
+		fields = append(slices.Clip(fields), "rym_id")
+	default:
+		http.Error(w, fmt.Sprintf("view %s has no CSV export", opts.View), http.StatusBadRequest)
+		return
+	}
+	cfg, err := configFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rym, uploaded := lastRYMList()
+	if rym == nil {
+		http.Error(w, "nothing to export yet; upload a CSV first", http.StatusNotFound)
+		return
+	}
+	all, loadedAt := currentLibrary()
+	resolveReleaseGroups(r.Context(), cfg, all, rym)
+	albums := opts.listedAlbums(opts.filterLibrary(all), opts.filterRYM(rym), cfg)
+	if opts.Fields != nil {
+		fields = opts.Fields
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	var buf bytes.Buffer
+	if err := writeAlbumsCSV(&buf, fields, albums); err != nil {
+		log.Printf("export.csv: %v", err)
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "export failed", http.StatusInternalServerError)
+		return
+	}
+	serveCached(w, r, buf.Bytes(), diffModTime(uploaded, loadedAt))
+}
+
+// The most recently parsed RYM list, kept for re-running the diff.
+var (
+	lastRYMMu sync.Mutex
+	lastRYM   []Album
+	lastRYMAt time.Time
+)
 
-	base, err := url.Parse(c.BaseURL)
-	if err != nil {
-		return nil, fmt.Errorf("parse base url: %w", err)
-	}
+func rememberRYM(albums []Album) {
+	lastRYMMu.Lock()
+	lastRYM, lastRYMAt = albums, time.Now()
+	lastRYMMu.Unlock()
+}
 
-	for {
-		u := base.ResolveReference(&url.URL{Path: "/Items"})
-		q := u.Query()
-		q.Set("IncludeItemTypes", "MusicAlbum")
-		q.Set("Recursive", "true")
-		q.Set("SortBy", "SortName")
-		q.Set("SortOrder", "Ascending")
-		q.Set("StartIndex", fmt.Sprintf("%d", startIndex))
-		q.Set("Limit", fmt.Sprintf("%d", pageSize))
-		q.Set("Fields", "PrimaryImageTag,AlbumArtist,AlbumArtists,ProductionYear,Overview")
-		u.RawQuery = q.Encode()
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
-		if err != nil {
-			return nil, err
-		}
-		req.Header.Set("X-MediaBrowser-Token", c.Token)
+// lastRYMList returns the last parsed RYM list and when it was uploaded.
+func lastRYMList() ([]Album, time.Time) {
+	lastRYMMu.Lock()
+	defer lastRYMMu.Unlock()
+	return lastRYM, lastRYMAt
+}
 
-		resp, err := c.HTTP.Do(req)
+// configFromRequest returns the active MatchConfig with any "threshold",
+// "second_pass_threshold" or "review_threshold" form values applied.
+func configFromRequest(r *http.Request) (MatchConfig, error) {
+	cfg := currentConfig()
+	for name, dst := range map[string]*float64{
+		"threshold":             &cfg.Threshold,
+		"second_pass_threshold": &cfg.SecondPassThreshold,
+		"review_threshold":      &cfg.ReviewThreshold,
+	} {
+		v := r.FormValue(name)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
 		if err != nil {
-			return nil, err
+			return cfg, fmt.Errorf("%s: %q is not a number", name, v)
 		}
-		defer resp.Body.Close()
+		*dst = f
+	}
+	if v := r.FormValue("mode"); v != "" {
+		cfg.Mode = v
+	}
+	if v := r.FormValue("year_mode"); v != "" {
+		cfg.YearMode = v
+	}
+	if v := r.FormValue("scoring"); v != "" {
+		cfg.Scoring = v
+	}
+	return cfg, cfg.Validate()
+}
 
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("bad status %d", resp.StatusCode)
+// readCSVUpload returns the CSV from the "csvfile" upload, else the one
+// at the "csvurl" URL, else the "csvtext" field. An uploaded or fetched
+// file may be gzipped.
+func readCSVUpload(r *http.Request) (io.Reader, error) {
+	_ = r.ParseMultipartForm(16 << 20) // 16 MB
+	if f, hdr, err := r.FormFile("csvfile"); err == nil && hdr != nil {
+		defer f.Close()
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, f); err != nil {
+			return nil, fmt.Errorf("failed to read uploaded file: %w", err)
 		}
-
-		var ir itemsResponse
-		if err := json.NewDecoder(resp.Body).Decode(&ir); err != nil {
+		return gunzipCSV(&buf)
+	}
+	if u := r.FormValue("csvurl"); strings.TrimSpace(u) != "" {
+		src, err := csvFetch.Fetch(r.Context(), u)
+		if err != nil {
 			return nil, err
 		}
+		return gunzipCSV(src)
+	}
+	return strings.NewReader(r.FormValue("csvtext")), nil
+}
 
-		all = append(all, ir.Items...)
-		startIndex += len(ir.Items)
-		if startIndex >= ir.TotalRecordCount || len(ir.Items) == 0 {
-			break
-		}
+// uploadedFiles returns the files of a multipart upload's csvfile
+// field, which may hold several.
+func uploadedFiles(r *http.Request) []*multipart.FileHeader {
+	_ = r.ParseMultipartForm(16 << 20) // as readCSVUpload
+	if r.MultipartForm == nil {
+		return nil
 	}
-	return all, nil
+	return r.MultipartForm.File["csvfile"]
 }
 
-func normalize(s string) string {
-	// decompose accents, then strip them
-	t := norm.NFD.String(strings.ToLower(s))
-	var b strings.Builder
-	for _, r := range t {
-		if unicode.Is(unicode.Mn, r) {
-			continue // skip diacritic
+// parseCSVUploads parses each of files on its own, as readCSVUpload and
+// parseListCSV would one, and merges their albums, keeping one of any
+// listed in several as dedupeRYM does within a file. A file that fails
+// is left out and reported in errs; the lines skipped in the others are
+// tagged with their file's name.
+func parseCSVUploads(files []*multipart.FileHeader, opts csvOptions) (albums []Album, skipped []LineError, errs []string) {
+	var filled []int
+	for _, fh := range files {
+		list, counts, bad, err := parseUploadedFile(fh, opts)
+		for _, e := range bad {
+			e.Err = fmt.Errorf("%s: %w", fh.Filename, e.Err)
+			skipped = append(skipped, e)
 		}
-		if unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsSpace(r) {
-			b.WriteRune(r)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: parse error: %v", fh.Filename, err))
+			continue
 		}
+		albums = append(albums, list...)
+		filled = append(filled, counts...)
 	}
-	return strings.Join(strings.Fields(b.String()), " ") // collapse spaces
+	albums, _ = dedupeRYM(albums, filled)
+	return albums, skipped, errs
 }
 
-// similarity returns [0..1] based on Levenshtein distance
-func similarity(a, b string) float64 {
-	if a == "" || b == "" {
-		return 0
-	}
-	d := levenshtein.DistanceForStrings([]rune(a), []rune(b), levenshtein.DefaultOptions)
-	maxLen := len([]rune(a))
-	if len([]rune(b)) > maxLen {
-		maxLen = len([]rune(b))
+func parseUploadedFile(fh *multipart.FileHeader, opts csvOptions) ([]Album, []int, []LineError, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	if maxLen == 0 {
-		return 1
+	defer f.Close()
+	src, err := gunzipCSV(f)
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	return 1 - float64(d)/float64(maxLen)
+	return parseListRows(src, opts)
 }
 
-func renderForm(w http.ResponseWriter, albums []Album, errMsg string) {
-	var jsonOut string
-	if len(albums) > 0 {
-		buf, _ := json.MarshalIndent(albums, "", "  ")
-		jsonOut = string(buf)
+// maxGunzippedCSV caps how large a gzipped CSV may grow once
+// decompressed, so a small upload can't inflate without bound.
+const maxGunzippedCSV = 256 << 20
+
+// gunzipCSV returns src decompressed if it starts with the gzip magic
+// bytes, whatever it was named, and src itself otherwise.
+func gunzipCSV(src io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(src)
+	if magic, _ := br.Peek(2); !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return br, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("bad gzip file: %w", err)
 	}
+	defer zr.Close()
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(zr, maxGunzippedCSV+1))
+	if err != nil {
+		return nil, fmt.Errorf("bad gzip file: %w", err)
+	}
+	if n > maxGunzippedCSV {
+		return nil, fmt.Errorf("gzipped CSV exceeds %d bytes uncompressed", maxGunzippedCSV)
+	}
+	return &buf, nil
+}
 
-	// Deduplicate albumList against RYM albums
-	var filtered []Album
-	for _, jfAlbum := range albumList {
-		jfTitle := normalize(strings.ToLower(jfAlbum.Name))
-		jfArtist := normalize(strings.ToLower(jfAlbum.AlbumArtist))
+// csvOptions tunes parseRymCSV.
+type csvOptions struct {
+	// Source is the site the list was exported from, sourceRYM or
+	// sourceDiscogs, for parseListCSV. Empty tells by the header.
+	Source string
 
-		duplicate := false
-		for _, rymAlbum := range albums {
-			rymTitle := normalize(strings.ToLower(rymAlbum.Name))
-			rymArtist := normalize(strings.ToLower(rymAlbum.AlbumArtist))
+	// Delimiter separates the fields: ',', ';' or '\t'. Zero sniffs it
+	// from the header, for spreadsheets exporting with semicolons and
+	// tools writing TSV.
+	Delimiter rune
 
-			titleSim := similarity(jfTitle, rymTitle)
-			artistSim := similarity(jfArtist, rymArtist)
+	// SkipBadLines drops lines the CSV reader rejects, reporting them as
+	// LineErrors, instead of failing the whole parse on the first one.
+	SkipBadLines bool
 
-			if titleSim > 0.75 && artistSim > 0.75 {
-				duplicate = true
-				break
-			}
-		}
-		if !duplicate {
-			filtered = append(filtered, jfAlbum)
+	// RejectEmptyNames fails the parse on a row whose artist or title is
+	// empty once normalized, e.g. a title of only punctuation. By default
+	// such rows are skipped and reported as LineErrors, since they could
+	// only ever produce confusing non-matches.
+	RejectEmptyNames bool
+
+	// TitleColumns names the header columns titles are read from, in
+	// order of preference: the first non-empty one becomes the album's
+	// Name and the rest its AltTitles, all of them tried when matching.
+	// Empty means the "Title" column, then "Title localized" if the
+	// export has it, so an original-script title is matched as well as
+	// the romanized one.
+	TitleColumns []string
+}
+
+// csvOptionsFrom reads csvOptions from the request's form values.
+func csvOptionsFrom(r *http.Request) csvOptions {
+	skip, _ := strconv.ParseBool(r.FormValue("skip_bad_lines"))
+	reject, _ := strconv.ParseBool(r.FormValue("reject_empty_names"))
+	var titles []string
+	for _, name := range strings.Split(r.FormValue("title_columns"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			titles = append(titles, name)
 		}
 	}
-	albumList = filtered
+	return csvOptions{
+		SkipBadLines:     skip,
+		RejectEmptyNames: reject,
+		TitleColumns:     titles,
+		Delimiter:        csvDelimiters[r.FormValue("delimiter")],
+		Source:           strings.ToLower(strings.TrimSpace(r.FormValue("source"))),
+	}
+}
 
-	err := pageTpl.ExecuteTemplate(w, "page", map[string]any{
-		"Albums": albumList,
-		"JSON":   jsonOut,
-		"Err":    errMsg,
-	})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// csvDelimiters maps the "delimiter" form values to csvOptions.Delimiter.
+// Anything else, including nothing, sniffs it.
+var csvDelimiters = map[string]rune{
+	",": ',', "comma": ',',
+	";": ';', "semicolon": ';',
+	"\t": '\t', "tab": '\t',
+}
+
+// sniffDelimiter guesses the delimiter from the header line: whichever of
+// comma, semicolon and tab it has most of, comma on a tie.
+func sniffDelimiter(header string) rune {
+	best, n := ',', strings.Count(header, ",")
+	for _, d := range []rune{';', '\t'} {
+		if c := strings.Count(header, string(d)); c > n {
+			best, n = d, c
+		}
 	}
+	return best
 }
 
-func ServeRymCSVForm(mux *http.ServeMux) {
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			renderForm(w, nil, "")
-			return
-		case http.MethodPost:
-			// Accept either file upload or textarea
-			var src io.Reader
+// LineError describes a CSV line that could not be parsed.
+type LineError struct {
+	Line int    `json:"line"`
+	Raw  string `json:"raw"` // the offending line, truncated
+	Err  error  `json:"-"`
+}
 
-			_ = r.ParseMultipartForm(16 << 20) // 16 MB
-			if f, hdr, err := r.FormFile("csvfile"); err == nil && hdr != nil {
-				defer f.Close()
-				var buf bytes.Buffer
-				if _, err := io.Copy(&buf, f); err != nil {
-					http.Error(w, "failed to read uploaded file: "+err.Error(), http.StatusBadRequest)
-					return
-				}
-				src = &buf
-			} else {
-				text := r.FormValue("csvtext")
-				src = strings.NewReader(text)
-			}
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %v: %s", e.Line, e.Err, e.Raw)
+}
 
-			albums, err := parseRymCSV(src)
-			if err != nil {
-				renderForm(w, nil, "Parse error: "+err.Error())
-				return
-			}
-			renderForm(w, albums, "")
-			return
-		default:
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-	})
+// Codes for CSVError, stable so API clients can tell a bad upload apart
+// from a server problem.
+const (
+	CSVErrEmpty          = "empty_csv"
+	CSVErrMissingColumns = "missing_columns"
+	CSVErrEncoding       = "encoding"
+	CSVErrMalformedLine  = "malformed_line"
+	CSVErrEmptyName      = "empty_name"
+	CSVErrUnknownSource  = "unknown_source"
+)
+
+// CSVError is a parse failure caused by the uploaded CSV itself.
+type CSVError struct {
+	Code     string
+	Detected int      // columns found, for CSVErrMissingColumns
+	Expected int      // columns required, for CSVErrMissingColumns
+	Missing  []string // required columns not found, for CSVErrMissingColumns
+	Line     int      // for CSVErrMalformedLine and CSVErrEmptyName
+	Err      error
+}
+
+func (e *CSVError) Error() string { return e.Err.Error() }
+func (e *CSVError) Unwrap() error { return e.Err }
+
+// errEmptyName reports a row whose artist or title normalizes to nothing.
+var errEmptyName = errors.New("artist or title is empty after normalization")
+
+// maxRawLine bounds how much of a bad line LineError quotes.
+const maxRawLine = 200
+
+// csvTable is a CSV read into rows, before any columns are interpreted.
+type csvTable struct {
+	rows  [][]string
+	lines []int // line each row starts on
+	raw   []string
+	bad   []LineError // lines skipped under SkipBadLines
+}
+
+// lineError quotes line of the input in a LineError.
+func (t *csvTable) lineError(line int, err error) LineError {
+	raw := ""
+	if line >= 1 && line <= len(t.raw) {
+		raw = strings.TrimRight(t.raw[line-1], "\r")
+	}
+	if len(raw) > maxRawLine {
+		raw = raw[:maxRawLine] + "…"
+	}
+	return LineError{Line: line, Raw: raw, Err: err}
 }
 
-func parseRymCSV(r io.Reader) ([]Album, error) {
+// readCSVTable reads the rows of a CSV export in any encoding toUTF8
+// knows. The table it returns, even with an error, holds the lines
+// skipped so far.
+func readCSVTable(r io.Reader, opts csvOptions) (*csvTable, error) {
+	t := &csvTable{}
 	// Ensure UTF-8, strip BOM if present
 	data, err := io.ReadAll(r)
 	if err != nil {
-		return nil, err
+		return t, err
+	}
+	data, err = toUTF8(data)
+	if err != nil {
+		return t, &CSVError{Code: CSVErrEncoding, Err: err}
 	}
-	data = stripBOM(data)
+	t.raw = strings.Split(string(data), "\n")
 
 	cr := csv.NewReader(bytes.NewReader(data))
 	cr.FieldsPerRecord = -1 // allow variable fields per row
-	rows, err := cr.ReadAll()
-	if err != nil {
-		return nil, err
+	cr.Comma = opts.Delimiter
+	if cr.Comma == 0 {
+		cr.Comma = sniffDelimiter(t.raw[0])
+	}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var pe *csv.ParseError
+			if !errors.As(err, &pe) {
+				return t, err
+			}
+			le := t.lineError(pe.StartLine, pe.Err)
+			if !opts.SkipBadLines || len(t.rows) == 0 {
+				return t, &CSVError{Code: CSVErrMalformedLine, Line: le.Line, Err: le}
+			}
+			t.bad = append(t.bad, le)
+			continue
+		}
+		line, _ := cr.FieldPos(0)
+		t.rows = append(t.rows, row)
+		t.lines = append(t.lines, line)
+	}
+	if len(t.rows) == 0 {
+		return t, &CSVError{Code: CSVErrEmpty, Err: errors.New("empty CSV")}
 	}
-	if len(rows) == 0 {
-		return nil, fmt.Errorf("empty CSV")
+	return t, nil
+}
+
+func parseRymCSV(r io.Reader, opts csvOptions) ([]Album, []LineError, error) {
+	t, err := readCSVTable(r, opts)
+	if err != nil {
+		return nil, t.bad, err
 	}
+	albums, _, bad, err := rymAlbums(t, opts)
+	return albums, bad, err
+}
+
+// rymAlbums reads the albums of a RYM export, with the filled-cell
+// counts dedupeRYM returns.
+func rymAlbums(t *csvTable, opts csvOptions) ([]Album, []int, []LineError, error) {
+	rows, rowLines, bad, lineError := t.rows, t.lines, t.bad, t.lineError
 
 	// Validate header (allow minor whitespace differences)
 	hdr := trimAll(rows[0])
+	col, err := locateColumns(hdr)
+	if err != nil {
+		return nil, nil, bad, err
+	}
 
-	if len(hdr) < 12 {
-		return nil, fmt.Errorf("header has %d columns, expected at least %d", len(hdr), 12)
+	titleCols := append([]int{col[colTitle]}, columnsNamed(hdr, "title localized")...)
+	if len(opts.TitleColumns) > 0 {
+		titleCols = nil
+		for _, name := range opts.TitleColumns {
+			idx := columnsNamed(hdr, name)
+			if len(idx) == 0 {
+				return nil, nil, bad, &CSVError{Code: CSVErrMissingColumns, Err: fmt.Errorf("no title column named %q", name)}
+			}
+			titleCols = append(titleCols, idx[0])
+		}
+	}
+
+	// Optional columns, found by header name wherever they are.
+	genreCols := columnsNamed(hdr, "genre", "genres", "primary genres", "secondary genres")
+	descriptorCols := columnsNamed(hdr, "descriptors")
+	localFirstCols := columnsNamed(hdr, "first name localized")
+	localLastCols := columnsNamed(hdr, "last name localized")
+	releaseCols := columnsNamed(hdr, "musicbrainz release id", "mbid")
+	groupCols := columnsNamed(hdr, "musicbrainz release group id")
+
+	width := 0 // columns a row needs to have all of rymColumns
+	for _, i := range col {
+		width = max(width, i+1)
 	}
 
 	var out []Album
+	var filled []int // non-empty cells of each album's row
 	for i := 1; i < len(rows); i++ {
 		cols := rows[i]
 		cols = trimAll(cols)
-		i, _ := strconv.Atoi(cols[6])
+		if len(cols) < width {
+			// Cut short, so whatever it has may be in the wrong columns.
+			bad = append(bad, lineError(rowLines[i], fmt.Errorf("row has %d columns, expected at least %d", len(cols), width)))
+			continue
+		}
+		cell := func(name string) string { return cols[col[name]] }
 		alb := Album{
-			RYMAlbumID:     cols[0], // from the CSV
-			Name:           cols[5],
-			ProductionYear: i,
-			AlbumArtist:    strings.TrimSpace(cols[1] + " " + cols[2]),
+			RYMAlbumID: cell(colRYMID), // from the CSV
 		}
-
-		// Parse release date (YYYY or YYYY-MM-DD)
-		/*if t, ok := parseYearOrDate(cols[6]); ok {
+		if t, ok := parseYearOrDate(cell(colReleaseDate)); ok {
 			alb.ReleaseDate = t
+			alb.ProductionYear = t.Year()
+		}
+		for _, c := range titleCols {
+			if c >= len(cols) || cols[c] == "" || slices.Contains(alb.titles(), cols[c]) {
+				continue
+			}
+			if alb.Name == "" {
+				alb.Name = cols[c]
+			} else {
+				alb.AltTitles = append(alb.AltTitles, cols[c])
+			}
+		}
+
+		// A band is all in one of the name columns, usually the last.
+		// The localized name is matched too, or alone if it is all
+		// there is.
+		alb.AlbumArtist = joinName(cell(colFirstName), cell(colLastName))
+		local := joinName(firstCell(cols, localFirstCols), firstCell(cols, localLastCols))
+		switch {
+		case alb.AlbumArtist == "":
+			alb.AlbumArtist = local
+		case local != "" && local != alb.AlbumArtist:
+			alb.AltArtists = []string{local}
+		}
+
+		if normalize(alb.Name, NormalizeConfig{}) == "" || normalize(alb.AlbumArtist, NormalizeConfig{}) == "" {
+			le := lineError(rowLines[i], errEmptyName)
+			if opts.RejectEmptyNames {
+				return nil, nil, bad, &CSVError{Code: CSVErrEmptyName, Line: le.Line, Err: le}
+			}
+			bad = append(bad, le)
+			continue
+		}
+
+		alb.Artists = splitArtists(alb.AlbumArtist)
+		alb.Genres = listCells(cols, genreCols)
+		alb.Descriptors = listCells(cols, descriptorCols)
+		for key, idx := range map[string][]int{providerMBRelease: releaseCols, providerMBReleaseGroup: groupCols} {
+			if ids := listCells(cols, idx); len(ids) > 0 {
+				if alb.ProviderIDs == nil {
+					alb.ProviderIDs = make(map[string]string)
+				}
+				alb.ProviderIDs[key] = ids[0]
+			}
 		}
-		*/
-		// Build a display name: prefer localized if present
-		first := cols[1]
-		last := cols[2]
-		alb.AlbumArtist = strings.TrimSpace(strings.Join([]string{first, last}, " "))
 
 		out = append(out, alb)
+		filled = append(filled, nonEmpty(cols))
+	}
+
+	out, filled = dedupeRYM(out, filled)
+	return out, filled, bad, nil
+}
+
+// The RYM export columns parseRymCSV reads, by header name.
+const (
+	colRYMID       = "RYM Album"
+	colFirstName   = "First Name"
+	colLastName    = "Last Name"
+	colTitle       = "Title"
+	colReleaseDate = "Release_Date"
+)
+
+// rymColumns lists the columns parseRymCSV reads, with other names they
+// go by and their position in RYM's export, which is assumed for a
+// header that names none of them.
+var rymColumns = []struct {
+	name    string
+	aliases []string
+	pos     int
+}{
+	{colRYMID, nil, 0},
+	{colFirstName, nil, 1},
+	{colLastName, nil, 2},
+	{colTitle, nil, 5},
+	{colReleaseDate, []string{"Release Date"}, 6},
+}
+
+// locateColumns maps the name of each of rymColumns to its index in
+// hdr. A header naming some of them must name them all. One naming none
+// is taken for a renamed but otherwise standard export and read by
+// position, provided it has the 12 columns RYM exports.
+func locateColumns(hdr []string) (map[string]int, error) {
+	col := make(map[string]int, len(rymColumns))
+	var missing []string
+	for _, c := range rymColumns {
+		if idx := columnsNamed(hdr, append([]string{c.name}, c.aliases...)...); len(idx) > 0 {
+			col[c.name] = idx[0]
+		} else {
+			missing = append(missing, c.name)
+		}
+	}
+	switch {
+	case len(missing) == 0:
+		return col, nil
+	case len(missing) < len(rymColumns):
+		return nil, &CSVError{
+			Code:    CSVErrMissingColumns,
+			Missing: missing,
+			Err:     fmt.Errorf("header lacks column(s) %s", strings.Join(missing, ", ")),
+		}
+	}
+	if len(hdr) < 12 {
+		return nil, &CSVError{
+			Code:     CSVErrMissingColumns,
+			Detected: len(hdr),
+			Expected: 12,
+			Missing:  missing,
+			Err:      fmt.Errorf("header has %d columns and none of the expected names, expected at least %d", len(hdr), 12),
+		}
+	}
+	for _, c := range rymColumns {
+		col[c.name] = c.pos
+	}
+	return col, nil
+}
+
+// releaseDateLayouts are the release date formats RYM exports.
+var releaseDateLayouts = []string{"2006-01-02", "2006-01", "2006"}
+
+// parseYearOrDate parses a RYM release date, reporting false for one in
+// none of releaseDateLayouts; "1997?" or "c. 1997" don't give 1997.
+func parseYearOrDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range releaseDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// nonEmpty counts the non-empty cells of a row.
+func nonEmpty(cols []string) int {
+	n := 0
+	for _, c := range cols {
+		if c != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// dedupeRYM collapses albums listed more than once, by RYM ID or, if
+// they have none, by artist and title, keeping the most complete row
+// where it first appeared: the one with the most cells filled in, and
+// among equals one whose names aren't all in one case. filled holds the
+// filled-cell count of each album's row; the counts of the rows kept are
+// returned alongside them.
+func dedupeRYM(albums []Album, filled []int) ([]Album, []int) {
+	better := func(i, j int) bool {
+		if filled[i] != filled[j] {
+			return filled[i] > filled[j]
+		}
+		return mixedCase(albums[i]) && !mixedCase(albums[j])
+	}
+	out := make([]Album, 0, len(albums))
+	kept := make([]int, 0, len(albums)) // index in albums of each one in out
+	seen := make(map[string]int)        // key -> index in out
+	for i, a := range albums {
+		key := "id:" + a.RYMAlbumID
+		if a.RYMAlbumID == "" {
+			key = normalize(a.AlbumArtist, NormalizeConfig{}) + "\x00" + normalize(a.Name, NormalizeConfig{})
+		}
+		if j, ok := seen[key]; ok {
+			if better(i, kept[j]) {
+				out[j], kept[j] = a, i
+			}
+			continue
+		}
+		seen[key] = len(out)
+		out = append(out, a)
+		kept = append(kept, i)
+	}
+	counts := make([]int, len(kept))
+	for j, i := range kept {
+		counts[j] = filled[i]
+	}
+	return out, counts
+}
+
+// mixedCase reports whether an album's artist and title are both cased
+// as written rather than all lower or all upper case.
+func mixedCase(a Album) bool {
+	for _, s := range []string{a.AlbumArtist, a.Name} {
+		if s == strings.ToLower(s) || s == strings.ToUpper(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitArtists returns the artists of a RYM split release, which RYM
+// credits as "Artist A / Artist B", or nil for a single artist.
+func splitArtists(artist string) []string {
+	if !strings.Contains(artist, " / ") {
+		return nil
+	}
+	var out []string
+	for _, a := range strings.Split(artist, " / ") {
+		if a = strings.TrimSpace(a); a != "" {
+			out = append(out, a)
+		}
+	}
+	if len(out) < 2 {
+		return nil
+	}
+	return out
+}
+
+// joinName joins the parts of a name with single spaces, leaving out
+// empty parts and any stray whitespace.
+func joinName(parts ...string) string {
+	return strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+}
+
+// columnsNamed returns the indexes of the header cells matching any of
+// names, ignoring case.
+func columnsNamed(hdr []string, names ...string) []int {
+	var out []int
+	for i, h := range hdr {
+		for _, n := range names {
+			if strings.EqualFold(h, n) {
+				out = append(out, i)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// firstCell returns the first of the given cells of cols, or "" if the
+// row is too short to have it.
+func firstCell(cols []string, idx []int) string {
+	if len(idx) == 0 || idx[0] >= len(cols) {
+		return ""
+	}
+	return cols[idx[0]]
+}
+
+// listCells splits the comma-separated lists in the given cells of cols
+// into one list, skipping cells the row is too short to have.
+func listCells(cols []string, idx []int) []string {
+	var out []string
+	for _, i := range idx {
+		if i >= len(cols) {
+			continue
+		}
+		for _, v := range strings.Split(cols[i], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				out = append(out, v)
+			}
+		}
 	}
+	return out
+}
+
+// errNotUTF8 is wrapped by toUTF8 when the input can't be read as text.
+var errNotUTF8 = errors.New("CSV is not valid UTF-8; please re-export it as UTF-8")
+
+// toUTF8 returns b as UTF-8 without a byte order mark. UTF-16 input with
+// a BOM is transcoded; anything else must already be valid UTF-8, since
+// guessing at legacy encodings would silently garble names.
+func toUTF8(b []byte) ([]byte, error) {
+	if len(b) >= 2 && (b[0] == 0xFF && b[1] == 0xFE || b[0] == 0xFE && b[1] == 0xFF) {
+		dec := textunicode.UTF16(textunicode.LittleEndian, textunicode.ExpectBOM).NewDecoder()
+		out, _, err := transform.Bytes(dec, b)
+		if err != nil {
+			return nil, fmt.Errorf("decode UTF-16: %w", err)
+		}
+		b = out
+	}
+	b = stripBOM(b)
+	if !utf8.Valid(b) {
+		line := 1 + bytes.Count(b[:invalidUTF8Offset(b)], []byte("\n"))
+		return nil, fmt.Errorf("%w (first bad byte on line %d)", errNotUTF8, line)
+	}
+	return b, nil
+}
 
-	return out, nil
+// invalidUTF8Offset returns the offset of the first invalid UTF-8
+// sequence in b, or len(b) if there is none.
+func invalidUTF8Offset(b []byte) int {
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 {
+			return i
+		}
+		i += size
+	}
+	return len(b)
 }
 
 func stripBOM(b []byte) []byte {
@@ -345,35 +2420,157 @@ func dbCreator() {
 }
 
 func main() {
+	forcePresentPath := flag.String("force-present", "force_present.json", "JSON file listing albums never to report as missing")
+	flag.DurationVar(&csvFetch.Timeout, "csvurl-timeout", csvFetch.Timeout, "timeout for fetching a CSV by URL")
+	flag.Int64Var(&csvFetch.MaxBytes, "csvurl-max-bytes", csvFetch.MaxBytes, "largest CSV accepted by URL, in bytes")
+	ignorePath := flag.String("ignore", "ignore.json", "JSON file listing RYM albums never to report as not in the library")
+	historyPath := flag.String("history", "history.jsonl", "JSON lines file the diff history is kept in")
+	jfURL := flag.String("jellyfin-url", os.Getenv("JELLYFIN_URL"), "Jellyfin base URL (default $JELLYFIN_URL)")
+	jfToken := flag.String("token", os.Getenv("JELLYFIN_TOKEN"), "Jellyfin API token (default $JELLYFIN_TOKEN)")
+	noServer := flag.Bool("no-server", false, "run without a Jellyfin server, with an empty library, e.g. just to diff RYM lists against each other")
+	diffInterval := flag.Duration("diff-interval", 0, "refresh the library and re-diff it against the last uploaded RYM list this often (0 disables)")
+	libraries := flag.String("libraries", "", "comma-separated names or IDs of the Jellyfin libraries to fetch albums from (default all)")
+	jfConcurrency := flag.Int("jellyfin-concurrency", 1, "album pages fetched from Jellyfin at once")
+	tokenInQuery := flag.Bool("token-in-query", false, "also send the Jellyfin token as the api_key query parameter, for proxies that strip headers")
+	historyMax := flag.Int("history-max", 1000, "diff history entries to keep")
+	aliasesPath := flag.String("aliases", "aliases.json", "JSON file mapping canonical artist names to their variants")
+	flag.StringVar(&webhook.URL, "webhook-url", os.Getenv("RYMCHECK_WEBHOOK_URL"), "URL to POST a JSON summary of each diff to (default $RYMCHECK_WEBHOOK_URL)")
+	flag.DurationVar(&webhook.Timeout, "webhook-timeout", webhook.Timeout, "timeout for each webhook attempt")
+	flag.IntVar(&webhook.Retries, "webhook-retries", webhook.Retries, "times a failed webhook POST is retried")
+	csvHosts := flag.String("csvurl-hosts", "", "comma-separated hosts CSVs may be fetched from (default any)")
+	authUser := flag.String("auth-user", os.Getenv("RYMCHECK_AUTH_USER"), "require HTTP basic auth with this user (default $RYMCHECK_AUTH_USER)")
+	authPass := flag.String("auth-pass", os.Getenv("RYMCHECK_AUTH_PASS"), "password for -auth-user (default $RYMCHECK_AUTH_PASS)")
+	libraryTTL := flag.Duration("library-ttl", 0, "refetch the library when a request finds it older than this (0 keeps it until restarted or POST /refresh)")
+	addr := flag.String("addr", ":8080", "address to listen on; port 0 picks a free one")
+	cliMode := flag.Bool("cli", false, "print the library albums missing from a RYM CSV and exit, without serving anything")
+	cliCSV := flag.String("csv", "", "with -cli, the RYM CSV to read (default stdin)")
+	cliFormat := flag.String("format", "table", "with -cli, the output format: table, json or csv")
+	flag.Parse()
 
-	go dbCreator()
+	if !*noServer && (*jfURL == "" || *jfToken == "") {
+		log.Fatal("no Jellyfin server configured: set -jellyfin-url and -token, or JELLYFIN_URL and JELLYFIN_TOKEN, or pass -no-server")
+	}
+	if (*authUser == "") != (*authPass == "") {
+		log.Fatal("-auth-user and -auth-pass must be set together")
+	}
+	for _, h := range strings.Split(*csvHosts, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			csvFetch.AllowedHosts = append(csvFetch.AllowedHosts, h)
+		}
+	}
 
-	ctx := context.Background()
-	jf := NewClient("https://jf.skaremyr.se", "96f1167856d947d0822307b911e4ce9b")
+	var err error
+	if forcePresent, err = loadKeyList(*forcePresentPath); err != nil {
+		log.Fatalf("load force-present list: %v", err)
+	}
+	if ignored, err = loadKeyList(*ignorePath); err != nil {
+		log.Fatalf("load ignore list: %v", err)
+	}
 
-	// If you have a user *session* token, you can fetch your userId from /Users/Me.
-	// If you're using an API key, supply a specific user's ID instead.
-	albums, err := jf.GetAllAlbums(ctx)
-	if err != nil {
-		panic(err)
+	if artistAliases, err = loadAliasMap(*aliasesPath); err != nil {
+		log.Fatalf("load artist aliases: %v", err)
+	}
+	if history, err = loadHistory(*historyPath, *historyMax); err != nil {
+		log.Fatalf("load diff history: %v", err)
 	}
-	for _, a := range albums {
 
-		albumList = append(albumList, a)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	notifyCtx = ctx
+	if *noServer {
+		log.Print("-no-server: running without Jellyfin, with an empty library")
+	} else {
+		jf := NewClient(*jfURL, *jfToken)
+		jf.TokenInQuery = *tokenInQuery
+		jf.Concurrency = *jfConcurrency
 
-		//fmt.Printf("%s (%d) — %s\n", a.Name, a.ProductionYear, a.AlbumArtist)
+		// A session token has a user whose items, play counts included,
+		// we can ask for. An API key has none, so it gets the global items.
+		if id, err := jf.GetCurrentUserID(ctx); err == nil {
+			jf.UserID = id
+			log.Printf("fetching library from /Users/%s/Items", id)
+		} else {
+			log.Printf("resolve Jellyfin user: %v; fetching library from /Items", err)
+		}
+		if *libraries != "" {
+			var wanted []string
+			for _, l := range strings.Split(*libraries, ",") {
+				if l = strings.TrimSpace(l); l != "" {
+					wanted = append(wanted, l)
+				}
+			}
+			folders, err := jf.GetLibraries(ctx)
+			if err != nil {
+				log.Fatalf("list Jellyfin libraries: %v", err)
+			}
+			if jf.ParentIDs, err = resolveLibraries(folders, wanted); err != nil {
+				log.Fatalf("-libraries: %v", err)
+			}
+		}
+		serverInfo.Client = jf
+		health.Client = jf
+		libraryLoader.Client = jf
+		if _, err := serverInfo.Get(ctx); err != nil {
+			log.Printf("fetch Jellyfin server info: %v", err)
+		}
 	}
-	sort.Slice(albumList, func(i, j int) bool {
-		ai := strings.ToLower(albumList[i].AlbumArtist)
-		aj := strings.ToLower(albumList[j].AlbumArtist)
-		if ai == aj {
-			return strings.ToLower(albumList[i].Name) < strings.ToLower(albumList[j].Name)
+
+	err = libraryLoader.Refresh(ctx)
+	if *cliMode {
+		if err != nil {
+			log.Fatalf("fetch Jellyfin library: %v", err)
 		}
-		return ai < aj
-	})
+		library, _ := currentLibrary()
+		if err := runCLI(os.Stdout, library, *cliCSV, *cliFormat); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err != nil {
+		// Diffing two RYM lists against each other still works.
+		if !errors.Is(err, errNoServer) {
+			log.Printf("fetch Jellyfin library: %v; continuing with an empty library", err)
+		}
+		setLibrary(nil)
+	}
+	libraryLoader.TTL = *libraryTTL
+	go dbCreator()
+
 	mux := http.NewServeMux()
 	ServeRymCSVForm(mux)
+	ServeAPI(mux)
+	ServeHealth(mux)
+
+	var scheduled sync.WaitGroup
+	if *diffInterval > 0 {
+		scheduled.Add(1)
+		go func() {
+			defer scheduled.Done()
+			runDiffSchedule(ctx, *diffInterval)
+		}()
+	}
 
-	log.Println("listening on http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", mux))
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var handler http.Handler = mux
+	if *authUser != "" {
+		handler = basicAuth(mux, *authUser, *authPass)
+	}
+	srv := &http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		log.Println("shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
+	log.Printf("listening on %s", ln.Addr())
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	scheduled.Wait()
 }
@@ -0,0 +1,873 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sampleCSV is a RYM export of three albums, all of them in
+// sampleLibrary, two with their names spelled differently.
+const sampleCSV = `RYM Album,First Name,Last Name,First Name localized,Last Name localized,Title,Release_Date,Rating,Ownership,Purchase Date,Media Type,Review,Review Title
+"1","","Radiohead","","","OK Computer","1997","8","n","","","",""
+"2","","Guns N' Roses","","","Appetite for Destruction","1987","5","n","","","",""
+"3","","Boards of Canada","","","Geogaddi","2002","9","n","","","",""
+`
+
+// sampleLibrary holds the albums of sampleCSV and "Kid A", which the
+// list lacks.
+func sampleLibrary() []Album {
+	return []Album{
+		{ID: "a", Name: "OK Computer", AlbumArtist: "Radiohead", ProductionYear: 1997},
+		{ID: "b", Name: "Appetite For Destruction", AlbumArtist: "Guns and Roses", ProductionYear: 1987},
+		{ID: "c", Name: "Kid A", AlbumArtist: "Radiohead", ProductionYear: 2000},
+		{ID: "d", Name: "Geogadi", AlbumArtist: "Boards of Canada", ProductionYear: 2002},
+	}
+}
+
+// withLibrary makes library the current one for the rest of the test.
+func withLibrary(t *testing.T, library []Album) {
+	t.Helper()
+	old, _ := currentLibrary()
+	setLibrary(library)
+	t.Cleanup(func() { setLibrary(old) })
+}
+
+// serve sends a request for target to mux, with form as its body if
+// given, and returns the response.
+func serve(t *testing.T, mux http.Handler, method, target string, form url.Values) (*http.Response, string) {
+	t.Helper()
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req := httptest.NewRequest(method, target, body)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec.Result(), rec.Body.String()
+}
+
+// withRYMList makes the albums of csv the last uploaded list for the
+// rest of the test.
+func withRYMList(t *testing.T, csv string) []Album {
+	t.Helper()
+	rym, _, err := parseListCSV(strings.NewReader(csv), csvOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _ := lastRYMList()
+	rememberRYM(rym)
+	t.Cleanup(func() { rememberRYM(old) })
+	return rym
+}
+
+func TestExportCSV(t *testing.T) {
+	withLibrary(t, sampleLibrary()[1:]) // without OK Computer
+	withRYMList(t, sampleCSV)
+	mux := http.NewServeMux()
+	ServeRymCSVForm(mux)
+	tests := []struct {
+		name, query, filename, body string
+	}{
+		{"default", "", "missing.csv", "artist,title,year\nRadiohead,Kid A,2000\n"},
+		{"missing", "view=missing", "missing.csv", "artist,title,year\nRadiohead,Kid A,2000\n"},
+		{"reverse", "view=reverse", "not-in-library.csv", "artist,title,year,rym_id\nRadiohead,OK Computer,1997,1\n"},
+		{"chosen fields", "view=missing&fields=title", "missing.csv", "title\nKid A\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := serve(t, mux, http.MethodGet, "/export.csv?"+tt.query, nil)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status %d: %s", resp.StatusCode, body)
+			}
+			if got, want := resp.Header.Get("Content-Disposition"), `attachment; filename="`+tt.filename+`"`; got != want {
+				t.Errorf("Content-Disposition = %q, want %q", got, want)
+			}
+			if body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestGetAllAlbumsPages(t *testing.T) {
+	tests := []struct {
+		name        string
+		fake        *fakeJellyfin
+		concurrency int
+		retries     int
+		want        int // albums returned, in order; -1 for an error
+		requests    int // album pages asked for
+	}{
+		{"one page", &fakeJellyfin{Albums: numberedAlbums(3)}, 1, 0, 3, 1},
+		{"empty library", &fakeJellyfin{}, 1, 0, 0, 1},
+		{"full pages", &fakeJellyfin{Albums: numberedAlbums(2 * albumPageSize)}, 1, 0, 2 * albumPageSize, 2},
+		{"last page short", &fakeJellyfin{Albums: numberedAlbums(albumPageSize + 1)}, 1, 0, albumPageSize + 1, 2},
+		{"server caps page size", &fakeJellyfin{Albums: numberedAlbums(120), PageSize: 50}, 1, 0, 120, 3},
+		{"concurrent, capped", &fakeJellyfin{Albums: numberedAlbums(120), PageSize: 50}, 4, 0, 120, 3},
+		{"total overstated", &fakeJellyfin{Albums: numberedAlbums(5), Total: 10}, 1, 0, 5, 2},
+		{"transient failure retried", &fakeJellyfin{Albums: numberedAlbums(120), PageSize: 50, Fail: map[int][]int{50: {503}}}, 1, 1, 120, 4},
+		{"concurrent, failure retried", &fakeJellyfin{Albums: numberedAlbums(120), PageSize: 50, Fail: map[int][]int{100: {502, 429}}}, 4, 2, 120, 5},
+		{"failure outlasts retries", &fakeJellyfin{Albums: numberedAlbums(120), PageSize: 50, Fail: map[int][]int{50: {500, 500}}}, 1, 1, -1, 3},
+		{"client error not retried", &fakeJellyfin{Albums: numberedAlbums(3), Fail: map[int][]int{0: {400}}}, 1, 3, -1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakeJellyfin(t, tt.fake)
+			c.Concurrency, c.Retries = tt.concurrency, tt.retries
+			albums, err := c.GetAllAlbums(context.Background())
+			switch {
+			case tt.want < 0 && err == nil:
+				t.Fatalf("GetAllAlbums returned %d albums, want an error", len(albums))
+			case tt.want >= 0 && err != nil:
+				t.Fatalf("GetAllAlbums: %v", err)
+			}
+			if tt.want >= 0 {
+				if len(albums) != tt.want {
+					t.Fatalf("got %d albums, want %d", len(albums), tt.want)
+				}
+				for i, a := range albums {
+					if a.ID != strconv.Itoa(i) {
+						t.Fatalf("album %d has ID %q, out of order", i, a.ID)
+					}
+				}
+			}
+			if got := len(tt.fake.Requests()); got != tt.requests {
+				t.Errorf("made %d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestGetAllAlbumsAuth(t *testing.T) {
+	tests := []struct {
+		name         string
+		token        string
+		tokenInQuery bool
+		wantErr      bool
+	}{
+		{"header", "secret", false, false},
+		{"query", "secret", true, false},
+		{"wrong token", "guess", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeJellyfin{Albums: numberedAlbums(2), Token: "secret"}
+			c := newFakeJellyfin(t, fake)
+			c.Token, c.TokenInQuery = tt.token, tt.tokenInQuery
+			_, err := c.GetAllAlbums(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetAllAlbums error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), tt.token) {
+				t.Errorf("error %q contains the token", err)
+			}
+		})
+	}
+}
+
+func TestGetAllAlbumsByLibrary(t *testing.T) {
+	fake := &fakeJellyfin{ByParent: map[string][]Album{
+		"lib1": numberedAlbums(2),
+		"lib2": numberedAlbums(3), // shares albums 0 and 1 with lib1
+	}}
+	c := newFakeJellyfin(t, fake)
+	c.ParentIDs, c.UserID = []string{"lib1", "lib2"}, "u1"
+	albums, err := c.GetAllAlbums(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(albums) != 3 {
+		t.Errorf("got %d albums, want the 3 distinct ones", len(albums))
+	}
+	for _, r := range fake.Requests() {
+		if r.URL.Path != "/Users/u1/Items" {
+			t.Errorf("asked for %s, want the user's items", r.URL.Path)
+		}
+	}
+}
+
+func TestNormalizeConjunctions(t *testing.T) {
+	cfg := NormalizeConfig{Conjunctions: true}
+	tests := []struct {
+		in, want string
+	}{
+		{"Guns N' Roses", "guns and roses"},
+		{"Guns N Roses", "guns and roses"},
+		{"Guns and Roses", "guns and roses"},
+		{"Guns ’N’ Roses", "guns and roses"},
+		{"Guns 'n' Roses", "guns and roses"},
+		{"Simon & Garfunkel", "simon and garfunkel"},
+		{"Simon&Garfunkel", "simon and garfunkel"},
+		{"Florence + the Machine", "florence and the machine"},
+		{"Rock 'n Roll", "rock and roll"},
+		{"C++", "c"},
+		{"Nine Inch Nails", "nine inch nails"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := normalize(tt.in, cfg); got != tt.want {
+				t.Errorf("normalize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGunsNRosesMatches(t *testing.T) {
+	library := []Album{{ID: "a", Name: "Appetite for Destruction", AlbumArtist: "Guns N' Roses"}}
+	for _, artist := range []string{"Guns N' Roses", "Guns N Roses", "Guns and Roses", "Guns ’n’ Roses"} {
+		t.Run(artist, func(t *testing.T) {
+			rym := []Album{{Name: "Appetite for Destruction", AlbumArtist: artist}}
+			matches := findMatches(library, rym, DefaultMatchConfig(), "")
+			if len(matches) != 1 || matches[0].ArtistSim != 1 {
+				t.Errorf("matches = %+v, want one with artist similarity 1", matches)
+			}
+		})
+	}
+}
+
+func TestNormalizeSeriesMarkers(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Part 1", "part 1"},
+		{"Pt. 1", "part 1"},
+		{"Pt 1", "part 1"},
+		{"Part I", "part 1"},
+		{"Part One", "part 1"},
+		{"Vol. 3", "volume 3"},
+		{"Volume III", "volume 3"},
+		{"No. 5", "number 5"},
+		{"Nr 5", "number 5"},
+		{"Greatest Hits Vol. II", "greatest hits volume 2"},
+		{"No Surprises", "no surprises"}, // no number after it
+		{"Part of Me", "part of me"},
+	}
+	cfg := NormalizeConfig{Abbreviations: true, Dots: true}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := normalize(tt.in, cfg); got != tt.want {
+				t.Errorf("normalize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+	t.Run("titles only", func(t *testing.T) {
+		cfg := DefaultMatchConfig()
+		if got := cfg.artistKey("Vol. 2"); got != "vol 2" {
+			t.Errorf("artist key of %q = %q, want it unexpanded", "Vol. 2", got)
+		}
+	})
+}
+
+func TestSimilarityAboveAgreesWithSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b      string
+		threshold float64
+		maxDist   int
+		want      float64 // 0 when rejected
+	}{
+		{"ok computer", "ok computer", 0.8, 0, 1},
+		{"ok computer", "ok komputer", 0.8, 0, similarity("ok computer", "ok komputer")},
+		{"ok computer", "kid a", 0.8, 0, 0},
+		{"geogaddi", "geogadi", 0.8, 0, similarity("geogaddi", "geogadi")},
+		{"geogaddi", "geogadi", 0.95, 0, 0},
+		{"appetite for destruction", "appetite for destructiom", 0.5, 1, 0}, // similar, but a substitution costs 2
+		{"appetite for destruction", "appetite for destructiom", 0.5, 2, similarity("appetite for destruction", "appetite for destructiom")},
+		{"", "kid a", 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := similarityAbove(tt.a, tt.b, tt.threshold, tt.maxDist); got != tt.want {
+				t.Errorf("similarityAbove = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	_, rym := syntheticLists(200, 100)
+	for _, threshold := range []float64{0.5, 0.8} {
+		for i, a := range rym {
+			b := rym[(i*7+3)%len(rym)].Name
+			full := similarity(a.Name, b)
+			bounded := similarityAbove(a.Name, b, threshold, 0)
+			if full > threshold && bounded != full || full <= threshold && bounded > threshold {
+				t.Errorf("%q/%q at %v: bounded %v, full %v", a.Name, b, threshold, bounded, full)
+			}
+		}
+	}
+}
+
+func BenchmarkSimilarity(b *testing.B) {
+	library, rym := syntheticLists(300, 150)
+	names := func(albums []Album) []string {
+		out := make([]string, len(albums))
+		for i, a := range albums {
+			out[i] = normalize(a.AlbumArtist+" "+a.Name, NormalizeConfig{})
+		}
+		return out
+	}
+	lib, list := names(library), names(rym)
+	b.Run("unbounded", func(b *testing.B) {
+		for b.Loop() {
+			for _, x := range lib[:50] {
+				for _, y := range list {
+					similarity(x, y)
+				}
+			}
+		}
+	})
+	b.Run("bounded", func(b *testing.B) {
+		for b.Loop() {
+			for _, x := range lib[:50] {
+				for _, y := range list {
+					similarityAbove(x, y, 0.8, 0)
+				}
+			}
+		}
+	})
+}
+
+func TestNormalizeAbbreviationDots(t *testing.T) {
+	tests := []struct {
+		variants []string
+		want     string
+	}{
+		{[]string{"Dr. Dre", "Dr Dre", "Dr.Dre", "dr.  dre"}, "dr dre"},
+		{[]string{"Mr. Bungle", "Mr Bungle", "Mr.Bungle"}, "mr bungle"},
+		{[]string{"St. Vincent", "St Vincent", "St.Vincent"}, "st vincent"},
+		{[]string{"R.E.M.", "REM", "R. E. M.", "R.E.M"}, "rem"},
+		{[]string{"Sunn O)))", "Sunn O"}, "sunn o"},
+	}
+	cfg := NormalizeConfig{Dots: true}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			for _, in := range tt.variants {
+				if got := normalize(in, cfg); got != tt.want {
+					t.Errorf("normalize(%q) = %q, want %q", in, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeNoiseWords(t *testing.T) {
+	tests := []struct {
+		variants []string
+		want     string
+	}{
+		{[]string{"Interstellar", "Interstellar (Original Motion Picture Soundtrack)", "Interstellar OST", "Interstellar: Soundtrack", "Interstellar - Original Soundtrack"}, "interstellar"},
+		{[]string{"Drive (Original Score)", "Drive – Music From the Motion Picture"}, "drive"},
+		{[]string{"Nevermind LP", "Nevermind"}, "nevermind"},
+		{[]string{"Soundtrack"}, "soundtrack"},       // nothing else, so kept
+		{[]string{"Ghost Stories"}, "ghost stories"}, // "ost" only as a word
+	}
+	cfg := DefaultMatchConfig().Title
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			for _, in := range tt.variants {
+				if got := normalize(in, cfg); got != tt.want {
+					t.Errorf("normalize(%q) = %q, want %q", in, got, tt.want)
+				}
+			}
+		})
+	}
+	t.Run("titles only", func(t *testing.T) {
+		if got := DefaultMatchConfig().artistKey("OST"); got != "ost" {
+			t.Errorf("artist key of %q = %q, want it kept", "OST", got)
+		}
+	})
+}
+
+func TestParseRymCSVKeepsFullestDuplicate(t *testing.T) {
+	const header = "RYM Album,First Name,Last Name,First Name localized,Last Name localized,Title,Release_Date,Rating,Ownership,Purchase Date,Media Type,Review,Review Title\n"
+	tests := []struct {
+		name string
+		rows string
+		want []string // "artist|title|year" of each album
+	}{
+		{
+			"fuller row later",
+			`"1","","radiohead","","","ok computer","","","","","","",""` + "\n" +
+				`"2","","Boards of Canada","","","Geogaddi","2002","9","n","","","",""` + "\n" +
+				`"1","","Radiohead","","","OK Computer","1997","8","n","","","",""` + "\n",
+			[]string{"Radiohead|OK Computer|1997", "Boards of Canada|Geogaddi|2002"},
+		},
+		{
+			"fuller row first",
+			`"1","","Radiohead","","","OK Computer","1997","8","n","","","",""` + "\n" +
+				`"1","","radiohead","","","ok computer","","","","","","",""` + "\n",
+			[]string{"Radiohead|OK Computer|1997"},
+		},
+		{
+			"equally full, better cased",
+			`"1","","RADIOHEAD","","","OK COMPUTER","1997","8","n","","","",""` + "\n" +
+				`"1","","Radiohead","","","OK Computer","1997","8","n","","","",""` + "\n",
+			[]string{"Radiohead|OK Computer|1997"},
+		},
+		{
+			"different IDs stay apart",
+			`"1","","Radiohead","","","OK Computer","1997","8","n","","","",""` + "\n" +
+				`"9","","Radiohead","","","OK Computer","2017","8","n","","","",""` + "\n",
+			[]string{"Radiohead|OK Computer|1997", "Radiohead|OK Computer|2017"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			albums, _, err := parseListCSV(strings.NewReader(header+tt.rows), csvOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, a := range albums {
+				got = append(got, fmt.Sprintf("%s|%s|%d", a.AlbumArtist, a.Name, a.ProductionYear))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenInQuery(t *testing.T) {
+	const token = "s3cret-token"
+	tests := []struct {
+		name         string
+		tokenInQuery bool
+		fail         []int // statuses of the first requests
+	}{
+		{"header only", false, nil},
+		{"query", true, nil},
+		{"query, server error", true, []int{500, 500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs strings.Builder
+			log.SetOutput(&logs)
+			t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+			fake := &fakeJellyfin{Albums: numberedAlbums(1), Token: token, Fail: map[int][]int{0: tt.fail}}
+			c := newFakeJellyfin(t, fake)
+			c.TokenInQuery, c.Retries = tt.tokenInQuery, 1
+			_, err := c.GetAllAlbums(context.Background())
+			if (err != nil) != (tt.fail != nil) {
+				t.Fatalf("GetAllAlbums error = %v", err)
+			}
+			if err != nil && strings.Contains(err.Error(), token) {
+				t.Errorf("error %q contains the token", err)
+			}
+			if strings.Contains(logs.String(), token) {
+				t.Errorf("log contains the token:\n%s", logs.String())
+			}
+			for _, r := range fake.Requests() {
+				if got := r.URL.Query().Get("api_key"); tt.tokenInQuery != (got == token) {
+					t.Errorf("api_key = %q with TokenInQuery %v", got, tt.tokenInQuery)
+				}
+				if got := r.Header.Get("X-MediaBrowser-Token"); got != token {
+					t.Errorf("X-MediaBrowser-Token = %q, want the token too", got)
+				}
+			}
+		})
+	}
+
+	t.Run("connection error", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		srv.Close()
+		c := NewClient(srv.URL, token)
+		c.TokenInQuery, c.Retries = true, 0
+		_, err := c.GetAllAlbums(context.Background())
+		if err == nil || strings.Contains(err.Error(), token) {
+			t.Errorf("error = %v, want one without the token", err)
+		}
+	})
+}
+
+func TestConjunctionNamesMatch(t *testing.T) {
+	tests := []struct {
+		variants []string
+	}{
+		{[]string{"Simon & Garfunkel", "Simon and Garfunkel", "Simon + Garfunkel"}},
+		{[]string{"Guns N' Roses", "Guns 'n' Roses", "Guns and Roses", "Guns & Roses"}},
+		{[]string{"Earth, Wind & Fire", "Earth Wind and Fire", "Earth, Wind and Fire"}},
+		{[]string{"Florence + the Machine", "Florence and the Machine", "Florence & The Machine"}},
+	}
+	cfg := DefaultMatchConfig()
+	for _, tt := range tests {
+		t.Run(tt.variants[0], func(t *testing.T) {
+			want := cfg.artistKey(tt.variants[0])
+			for _, v := range tt.variants[1:] {
+				if got := cfg.artistKey(v); got != want {
+					t.Errorf("artist key of %q = %q, want %q as for %q", v, got, want, tt.variants[0])
+				}
+			}
+		})
+	}
+	t.Run("rule off", func(t *testing.T) {
+		off := cfg
+		off.Artist.Conjunctions = false
+		if off.artistKey("Simon & Garfunkel") == off.artistKey("Simon and Garfunkel") {
+			t.Error("keys agree with the rule off")
+		}
+	})
+}
+
+func TestResolveLibraries(t *testing.T) {
+	fake := &fakeJellyfin{Libraries: []VirtualFolder{
+		{Name: "Music", ItemID: "m1", CollectionType: "music"},
+		{Name: "Vinyl Rips", ItemID: "m2", CollectionType: "music"},
+		{Name: "Audiobooks", ItemID: "b1", CollectionType: "books"},
+	}}
+	folders, err := newFakeJellyfin(t, fake).GetLibraries(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		wanted  []string
+		want    []string
+		wantErr bool
+	}{
+		{"by name", []string{"Music"}, []string{"m1"}, false},
+		{"any case", []string{"vinyl rips"}, []string{"m2"}, false},
+		{"by ID", []string{"m2"}, []string{"m2"}, false},
+		{"in the order asked", []string{"Vinyl Rips", "music"}, []string{"m2", "m1"}, false},
+		{"repeats dropped", []string{"Music", "m1"}, []string{"m1"}, false},
+		{"unknown name", []string{"Music", "Podcasts"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveLibraries(folders, tt.wanted)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveLibraries error = %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("resolveLibraries(%q) = %q, want %q", tt.wanted, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeAmpersandKeepsSlashNames(t *testing.T) {
+	cfg := DefaultMatchConfig().Artist
+	tests := []struct {
+		in, want string
+	}{
+		{"Simon & Garfunkel", "simon and garfunkel"},
+		{"Simon and Garfunkel", "simon and garfunkel"},
+		{"Florence + the Machine", "florence and the machine"},
+		{"AC/DC", "acdc"},
+		{"AC / DC", "ac dc"},
+		{"Ac/Dc", "acdc"},
+		{"M&M", "m and m"},
+		{"+44", "44"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := normalize(tt.in, cfg); got != tt.want {
+				t.Errorf("normalize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSniffDelimiter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   rune
+	}{
+		{"RYM Album,First Name,Last Name,Title", ','},
+		{"RYM Album;First Name;Last Name;Title", ';'},
+		{"RYM Album\tFirst Name\tLast Name\tTitle", '\t'},
+		{"RYM Album;First Name, Jr;Last Name;Title", ';'},
+		{"Title", ','},
+		{"a,b;c", ','}, // a tie
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := sniffDelimiter(tt.header); got != tt.want {
+				t.Errorf("sniffDelimiter(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDelimitedExports(t *testing.T) {
+	// sampleCSV has no commas inside its fields.
+	semicolons := strings.ReplaceAll(sampleCSV, ",", ";")
+	tabs := strings.ReplaceAll(sampleCSV, ",", "\t")
+	tests := []struct {
+		name      string
+		csv       string
+		delimiter rune
+		want      int // albums; -1 for an error
+	}{
+		{"comma", sampleCSV, 0, 3},
+		{"semicolon, sniffed", semicolons, 0, 3},
+		{"tab, sniffed", tabs, 0, 3},
+		{"semicolon, forced", semicolons, ';', 3},
+		{"forced wrong", semicolons, ',', -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			albums, _, err := parseListCSV(strings.NewReader(tt.csv), csvOptions{Delimiter: tt.delimiter})
+			if tt.want < 0 {
+				if err == nil {
+					t.Errorf("parsed %d albums, want an error", len(albums))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(albums) != tt.want {
+				t.Fatalf("parsed %d albums, want %d", len(albums), tt.want)
+			}
+			if a := albums[1]; a.AlbumArtist != "Guns N' Roses" || a.Name != "Appetite for Destruction" || a.ProductionYear != 1987 {
+				t.Errorf("second album = %s / %s (%d), want its own columns", a.AlbumArtist, a.Name, a.ProductionYear)
+			}
+		})
+	}
+}
+
+func TestCSVOptionsDelimiterField(t *testing.T) {
+	tests := []struct {
+		value string
+		want  rune
+	}{
+		{"", 0}, {"auto", 0}, {";", ';'}, {"semicolon", ';'}, {"tab", '\t'}, {"comma", ','},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?"+url.Values{"delimiter": {tt.value}}.Encode(), nil)
+			if got := csvOptionsFrom(r).Delimiter; got != tt.want {
+				t.Errorf("delimiter %q gives %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestClientUsesInjectedHTTP(t *testing.T) {
+	var asked []string
+	c := NewClient("http://jellyfin.invalid", "t")
+	c.HTTP = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		asked = append(asked, r.URL.Query().Get("StartIndex"))
+		rec := httptest.NewRecorder()
+		writeFakeJSON(rec, itemsResponse{Items: numberedAlbums(2), TotalRecordCount: 2})
+		return rec.Result(), nil
+	})}
+	albums, err := c.GetAllAlbums(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(albums) != 2 || !slices.Equal(asked, []string{"0"}) {
+		t.Errorf("got %d albums asking for pages %q, want 2 from page 0", len(albums), asked)
+	}
+}
+
+func TestGetAllAlbumsAgainstServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   int   // TotalRecordCount reported
+		items   int   // albums the server has
+		status  int   // of the page at 200, if not 200
+		want    int   // albums returned
+		starts  []int // StartIndex of each request
+		wantErr int   // status of the error, if any
+	}{
+		{"one page", 3, 3, 0, 3, []int{0}, 0},
+		{"stops at the total", 250, 250, 0, 250, []int{0, 200}, 0},
+		{"total reached exactly", 200, 200, 0, 200, []int{0}, 0},
+		{"non-200", 250, 250, http.StatusForbidden, 0, []int{0, 200}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all := numberedAlbums(tt.items)
+			var starts []int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				start, _ := strconv.Atoi(r.URL.Query().Get("StartIndex"))
+				limit, _ := strconv.Atoi(r.URL.Query().Get("Limit"))
+				starts = append(starts, start)
+				if start > 0 && tt.status != 0 {
+					http.Error(w, "nope", tt.status)
+					return
+				}
+				writeFakeJSON(w, itemsResponse{Items: all[start:min(start+limit, len(all))], TotalRecordCount: tt.total})
+			}))
+			defer srv.Close()
+			c := NewClient(srv.URL, "t")
+			c.HTTP = srv.Client()
+
+			albums, err := c.GetAllAlbums(context.Background())
+			var se *statusError
+			switch {
+			case tt.wantErr != 0 && (!errors.As(err, &se) || se.Status != tt.wantErr):
+				t.Fatalf("error = %v, want status %d", err, tt.wantErr)
+			case tt.wantErr == 0 && err != nil:
+				t.Fatal(err)
+			}
+			if len(albums) != tt.want {
+				t.Errorf("got %d albums, want %d", len(albums), tt.want)
+			}
+			if !slices.Equal(starts, tt.starts) {
+				t.Errorf("asked for pages at %v, want %v", starts, tt.starts)
+			}
+		})
+	}
+}
+
+func TestSortByNearestMatch(t *testing.T) {
+	library := []Album{
+		{ID: "far", Name: "Nothing Like It", AlbumArtist: "Nobody"},
+		{ID: "near", Name: "OK Computer", AlbumArtist: "Blur"},
+		{ID: "owned", Name: "Kid A", AlbumArtist: "Radiohead"},
+	}
+	rym := []Album{{Name: "OK Computer", AlbumArtist: "Radiohead"}, {Name: "Kid A", AlbumArtist: "Radiohead"}}
+	cfg := DefaultMatchConfig()
+	cfg.ReviewThreshold = 0 // or the near miss needs review instead
+	_, diffed := summarizeDiff(library, rym, cfg)
+	for _, tt := range []struct {
+		name   string
+		diffed *[]missingAlbum
+	}{{"diffed here", nil}, {"diffed by the handler", &diffed}} {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := viewOptions{View: "missing", Sort: "score", diffed: tt.diffed}
+			var got []string
+			for _, a := range opts.missingAlbums(library, rym, cfg) {
+				got = append(got, a.ID)
+			}
+			if want := []string{"near", "far"}; !slices.Equal(got, want) {
+				t.Errorf("missing albums = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMissingAndReview(t *testing.T) {
+	library := []Album{
+		{ID: "far", Name: "Nothing Like It", AlbumArtist: "Nobody"},
+		{ID: "near", Name: "OK Computer", AlbumArtist: "Blur"},
+	}
+	rym := []Album{{Name: "OK Computer", AlbumArtist: "Radiohead"}}
+	tests := []struct {
+		name        string
+		review      float64
+		wantMissing []string
+		wantReview  int
+	}{
+		{"in the band", 0.5, []string{"far"}, 1},
+		{"below the bound", 0.7, []string{"far", "near"}, 0},
+		{"no band", 0, []string{"far", "near"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultMatchConfig()
+			cfg.ReviewThreshold = tt.review
+			missing, review := viewOptions{View: "missing"}.missingAndReview(library, rym, cfg)
+			var got []string
+			for _, a := range missing {
+				got = append(got, a.ID)
+			}
+			if !slices.Equal(got, tt.wantMissing) {
+				t.Errorf("missing = %v, want %v", got, tt.wantMissing)
+			}
+			if len(review) != tt.wantReview {
+				t.Fatalf("review = %v, want %d pairs", review, tt.wantReview)
+			}
+			if len(review) > 0 && (review[0].Jellyfin.ID != "near" || review[0].RYM.AlbumArtist != "Radiohead") {
+				t.Errorf("review pair = %+v, want near beside Radiohead's", review[0])
+			}
+		})
+	}
+}
+
+func TestFormListsPairsNeedingReview(t *testing.T) {
+	withLibrary(t, []Album{{ID: "near", Name: "OK Computer", AlbumArtist: "Blur"}})
+	mux := http.NewServeMux()
+	ServeRymCSVForm(mux)
+	withRYMList(t, sampleCSV)
+	resp, body := serve(t, mux, http.MethodGet, "/rerun?view=missing", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	for _, want := range []string{"Needs Review (1)", "Blur – OK Computer", "Radiohead – OK Computer"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page lacks %q", want)
+		}
+	}
+}
+
+func TestParseCSVUploadsKeepsFullestDuplicate(t *testing.T) {
+	const header = "RYM Album,First Name,Last Name,First Name localized,Last Name localized,Title,Release_Date,Rating,Ownership,Purchase Date,Media Type,Review,Review Title\n"
+	files := map[string]string{
+		"a.csv": header + `"1","","Radiohead","","","OK Computer","","","","","","",""` + "\n",
+		"b.csv": header + `"1","","Radiohead","","","OK Computer","1997","8","n","","","",""` + "\n",
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, name := range []string{"a.csv", "b.csv"} {
+		w, err := mw.CreateFormFile("csvfile", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, files[name])
+	}
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	albums, _, errs := parseCSVUploads(uploadedFiles(req), csvOptions{})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(albums) != 1 || albums[0].Name != "OK Computer" || albums[0].ProductionYear != 1997 {
+		t.Errorf("albums = %+v, want the second file's fuller row", albums)
+	}
+}
+
+func TestFormRejectsBadOptions(t *testing.T) {
+	withLibrary(t, sampleLibrary())
+	withRYMList(t, sampleCSV)
+	mux := http.NewServeMux()
+	ServeRymCSVForm(mux)
+	tests := []struct {
+		name, method, target string
+		form                 url.Values
+		want                 string
+	}{
+		{"form", http.MethodGet, "/?view=nope", nil, `unknown view &#34;nope&#34;`},
+		{"upload", http.MethodPost, "/", url.Values{"csvtext": {sampleCSV}, "view": {"nope"}}, `unknown view &#34;nope&#34;`},
+		{"upload config", http.MethodPost, "/", url.Values{"csvtext": {sampleCSV}, "threshold": {"2"}}, "threshold 2 out of range"},
+		{"rerun", http.MethodGet, "/rerun?sort=nope", nil, "sort"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := serve(t, mux, tt.method, tt.target, tt.form)
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+			}
+			if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+				t.Errorf("Content-Type = %q, want HTML", ct)
+			}
+			if !strings.Contains(body, tt.want) {
+				t.Errorf("page lacks %q", tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// runDiffSchedule refreshes the library and re-diffs it against the last
+// uploaded RYM list every interval until ctx is done. A run is recorded,
+// and sent to the webhook, only when its counts differ from the latest
+// in the history, so a monitor notifies on change alone.
+func runDiffSchedule(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			scheduledDiff(ctx)
+		}
+	}
+}
+
+// scheduledDiff is one run of runDiffSchedule. A failed refresh keeps
+// the library as it was.
+func scheduledDiff(ctx context.Context) {
+	if err := libraryLoader.Refresh(ctx); err != nil && ctx.Err() == nil && !errors.Is(err, errNoServer) {
+		log.Printf("scheduled diff: refresh library: %v; keeping the old one", err)
+	}
+
+	rym, _ := lastRYMList()
+	if rym == nil {
+		return // nothing to diff against until a list is uploaded
+	}
+	library, _ := currentLibrary()
+	cfg := currentConfig()
+	resolveReleaseGroups(ctx, cfg, library, rym)
+	e, missing := summarizeDiff(library, rym, cfg)
+	if last, ok := history.Last(); ok && last.sameCounts(e) {
+		return
+	}
+	if err := history.Add(e); err != nil {
+		log.Printf("scheduled diff: record history: %v", err)
+	}
+	webhook.Notify(ctx, e, missing)
+}
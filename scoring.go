@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Scoring rules: how a pair's title and artist similarities make its
+// score, which must exceed the threshold.
+const (
+	// ScoreWeighted averages the two, weighted by MatchConfig.TitleWeight
+	// and ArtistWeight, so a strong field can carry a weaker one.
+	ScoreWeighted = "weighted"
+	// ScoreBoth scores a pair by the lower of the two, so both must
+	// exceed the threshold on their own.
+	ScoreBoth = "both"
+)
+
+// scoring returns the configured scoring rule, ScoreWeighted if none is.
+func (c MatchConfig) scoring() string {
+	if c.Scoring == "" {
+		return ScoreWeighted
+	}
+	return c.Scoring
+}
+
+// validateScoring reports the first out-of-range scoring setting in c.
+func (c MatchConfig) validateScoring() error {
+	switch c.scoring() {
+	case ScoreWeighted:
+		if c.TitleWeight < 0 || c.ArtistWeight < 0 {
+			return fmt.Errorf("title_weight and artist_weight must not be negative")
+		}
+		if c.TitleWeight+c.ArtistWeight == 0 {
+			return fmt.Errorf("title_weight and artist_weight must not both be zero")
+		}
+	case ScoreBoth:
+	default:
+		return fmt.Errorf("unknown scoring %q (want weighted or both)", c.Scoring)
+	}
+	return nil
+}
+
+// weights returns the title and artist weights, scaled to sum to 1.
+func (c MatchConfig) weights() (title, artist float64) {
+	sum := c.TitleWeight + c.ArtistWeight
+	return c.TitleWeight / sum, c.ArtistWeight / sum
+}
+
+// score combines a pair's title and artist similarities.
+func (c MatchConfig) score(titleSim, artistSim float64) float64 {
+	if c.scoring() == ScoreBoth {
+		return min(titleSim, artistSim)
+	}
+	wt, wa := c.weights()
+	return wt*titleSim + wa*artistSim
+}
+
+// fieldCutoffs are the similarities the title and the artist must each
+// exceed for the score to exceed cutoff, the other field being perfect
+// at best. Comparisons and candidate indexes can then give up on a pair
+// early without losing one the weighted score would have kept.
+func (c MatchConfig) fieldCutoffs(cutoff float64) (title, artist float64) {
+	if c.scoring() == ScoreBoth {
+		return cutoff, cutoff
+	}
+	wt, wa := c.weights()
+	title, artist = 0, 0
+	if wt > 0 {
+		title = max((cutoff-wa)/wt, 0)
+	}
+	if wa > 0 {
+		artist = max((cutoff-wt)/wa, 0)
+	}
+	return title, artist
+}
+
+// ScoringRule describes the scoring rule for the page.
+func (c MatchConfig) ScoringRule() string {
+	if c.scoring() == ScoreBoth {
+		return "artist and title similarity must both exceed it"
+	}
+	wt, wa := c.weights()
+	return fmt.Sprintf("%.2g × title similarity + %.2g × artist similarity must exceed it", wt, wa)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// webhookNotifier POSTs a JSON summary of each diff to a URL, e.g. a
+// Discord or Slack incoming webhook. Such URLs embed their own secret,
+// so they are never logged.
+type webhookNotifier struct {
+	URL        string // empty disables notifications
+	HTTP       *http.Client
+	Timeout    time.Duration // per attempt
+	Retries    int
+	RetryDelay time.Duration // doubled after each failed attempt
+
+	// MaxMissing caps how many missing albums are listed in a payload.
+	MaxMissing int
+}
+
+// webhook is configured from flags in main.
+var webhook = webhookNotifier{
+	HTTP:       &http.Client{},
+	Timeout:    10 * time.Second,
+	Retries:    3,
+	RetryDelay: 2 * time.Second,
+	MaxMissing: 50,
+}
+
+// notifyCtx bounds the notifications recordDiff sends in the background,
+// which outlive the requests that made them: main cancels it on
+// shutdown.
+var notifyCtx = context.Background()
+
+// webhookPayload is what a notification sends. Text and Content carry a
+// one-line summary for Slack and Discord, which show those fields.
+// Albums are reduced to artist, title and year, so nothing like a
+// Jellyfin ID or the list's source URL leaves the server.
+type webhookPayload struct {
+	Text      string         `json:"text"`
+	Content   string         `json:"content"`
+	Summary   historyEntry   `json:"summary"`
+	Missing   []webhookAlbum `json:"missing"`
+	Truncated bool           `json:"truncated,omitempty"` // more were missing than listed
+}
+
+type webhookAlbum struct {
+	Artist string `json:"artist"`
+	Title  string `json:"title"`
+	Year   int    `json:"year,omitempty"`
+}
+
+// Notify sends the summary e of a diff and its missing albums, retrying
+// failed attempts, until done or ctx ends. It blocks meanwhile, so
+// callers run it in the background, and only logs failures.
+func (n webhookNotifier) Notify(ctx context.Context, e historyEntry, missing []missingAlbum) {
+	if n.URL == "" {
+		return
+	}
+	p := webhookPayload{Summary: e, Missing: []webhookAlbum{}}
+	p.Text = fmt.Sprintf("rymcheck: %d of %d library albums missing from RYM (%d matched)", e.Missing, e.Library, e.Matched)
+	p.Content = p.Text
+	for i, a := range missing {
+		if i == n.MaxMissing {
+			p.Truncated = true
+			break
+		}
+		p.Missing = append(p.Missing, webhookAlbum{Artist: a.AlbumArtist, Title: a.Name, Year: a.Year()})
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("webhook: %v", err)
+		return
+	}
+
+	delay := n.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err = n.post(ctx, body)
+		if err == nil {
+			return
+		}
+		if attempt >= n.Retries {
+			log.Printf("webhook: giving up after %d attempts: %v", attempt+1, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("webhook: giving up after %d attempts: %v", attempt+1, err)
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post sends body once. Its errors leave out the URL.
+func (n webhookNotifier) post(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, n.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("bad webhook URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.HTTP.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("bad status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// notifyUntilCancelled runs n.Notify with a context it cancels once the
+// webhook has had a request, and fails t unless Notify soon returns.
+func notifyUntilCancelled(t *testing.T, n webhookNotifier, requests *atomic.Int32) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n.Notify(ctx, historyEntry{Library: 1, Missing: 1}, []missingAlbum{{Album: Album{Name: "Kid A", AlbumArtist: "Radiohead"}}})
+	}()
+	for requests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notify didn't stop when its context ended")
+	}
+}
+
+func TestNotifyRequestEndsWithContext(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release // no answer while Notify waits for one
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	n := webhookNotifier{URL: srv.URL, HTTP: srv.Client(), Timeout: time.Hour}
+	notifyUntilCancelled(t, n, &requests)
+}
+
+func TestNotifyRetryWaitEndsWithContext(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+	n := webhookNotifier{URL: srv.URL, HTTP: srv.Client(), Timeout: time.Second, Retries: 3, RetryDelay: time.Hour}
+	notifyUntilCancelled(t, n, &requests)
+	if got := requests.Load(); got != 1 {
+		t.Errorf("made %d requests, want 1", got)
+	}
+}
+
+func TestScheduledDiffNotifiesWithinItsContext(t *testing.T) {
+	withLibrary(t, sampleLibrary())
+	withRYMList(t, sampleCSV)
+	old := history
+	history, _ = loadHistory("", 10)
+	t.Cleanup(func() { history = old })
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+	oldHook := webhook
+	webhook = webhookNotifier{URL: srv.URL, HTTP: srv.Client(), Timeout: time.Second, Retries: 3, RetryDelay: time.Hour}
+	t.Cleanup(func() { webhook = oldHook })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { defer close(done); scheduledDiff(ctx) }()
+	for requests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduledDiff kept retrying the webhook after its context ended")
+	}
+}
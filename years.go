@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Year modes: how the release years of a pair affect its match.
+const (
+	// YearIgnore leaves years out of matching.
+	YearIgnore = "ignore"
+	// YearGate rejects pairs whose years differ by more than
+	// MatchConfig.YearTolerance.
+	YearGate = "gate"
+	// YearSoft adds a bonus of up to MatchConfig.YearWeight to the score
+	// of a pair whose years are close and takes as much off one whose
+	// years are far apart; see yearAdjustment.
+	YearSoft = "soft"
+)
+
+// yearMode returns the configured year mode, YearIgnore if none is.
+func (c MatchConfig) yearMode() string {
+	if c.YearMode == "" {
+		return YearIgnore
+	}
+	return c.YearMode
+}
+
+// validateYears reports the first out-of-range year setting in c.
+func (c MatchConfig) validateYears() error {
+	switch c.yearMode() {
+	case YearIgnore, YearGate, YearSoft:
+	default:
+		return fmt.Errorf("unknown year_mode %q (want ignore, gate or soft)", c.YearMode)
+	}
+	if c.YearTolerance < 0 {
+		return fmt.Errorf("year_tolerance must not be negative")
+	}
+	if c.YearWeight < 0 || c.YearWeight > 1 {
+		return fmt.Errorf("year_weight %v out of range [0,1]", c.YearWeight)
+	}
+	if c.yearMode() == YearSoft && c.YearFalloff <= 0 {
+		return fmt.Errorf("year_falloff must be positive in soft year mode")
+	}
+	return nil
+}
+
+// yearDiff returns how many years apart a and b were released, and
+// false if either year is unknown.
+func yearDiff(a, b Album) (int, bool) {
+	ya, yb := a.Year(), b.Year()
+	if ya == 0 || yb == 0 {
+		return 0, false
+	}
+	if ya > yb {
+		return ya - yb, true
+	}
+	return yb - ya, true
+}
+
+// yearAdjustment is the soft-mode change to a pair's score: YearWeight
+// for the same year, falling linearly to nothing at YearFalloff years
+// apart and on to -YearWeight at twice that. Unknown years count as
+// neither close nor far.
+func (c MatchConfig) yearAdjustment(a, b Album) float64 {
+	d, ok := yearDiff(a, b)
+	if !ok {
+		return 0
+	}
+	return c.YearWeight * max(1-float64(d)/c.YearFalloff, -1)
+}
+
+// yearCutoff is the similarity an artist or title must exceed to stay
+// in the running at threshold: lower in soft mode, where a close year
+// can still lift the pair over threshold.
+func (c MatchConfig) yearCutoff(threshold float64) float64 {
+	if c.yearMode() == YearSoft {
+		return max(threshold-c.YearWeight, 0)
+	}
+	return threshold
+}